@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"vybar/election"
+	"vybar/export"
+	"vybar/store"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func run() error {
+	var (
+		storePath    = flag.String("store", os.Getenv("STORE_PATH"), "path to the bot database, the bot must be stopped or a copy used")
+		electionFile = flag.String("election", os.Getenv("ELECTION_FILE"), "path to the election definition")
+		data         = flag.String("data", "tally", "what to export: tally, submissions or verdicts")
+		format       = flag.String("format", "csv", "output format: csv or json")
+		output       = flag.String("o", "", "output file, stdout by default")
+		precinct     = flag.String("precinct", "", "export only submissions of the precinct")
+		from         = flag.String("from", "", "export only submissions received at or after the time, RFC3339")
+		to           = flag.String("to", "", "export only submissions received before the time, RFC3339")
+		status       = flag.String("status", "", "export only submissions with the status: pending, escalated or accepted")
+	)
+	flag.Parse()
+
+	if *storePath == "" || *electionFile == "" {
+		return fmt.Errorf("-store and -election are required")
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	switch *data {
+	case "tally", "submissions", "verdicts":
+	default:
+		return fmt.Errorf("unknown data %q", *data)
+	}
+	switch store.SubmissionStatus(*status) {
+	case "", store.StatusPending, store.StatusEscalated, store.StatusAccepted:
+	default:
+		return fmt.Errorf("unknown status %q", *status)
+	}
+
+	filter := export.Filter{
+		Precinct: *precinct,
+		Status:   store.SubmissionStatus(*status),
+	}
+	var err error
+	if filter.From, err = parseTime(*from); err != nil {
+		return err
+	}
+	if filter.To, err = parseTime(*to); err != nil {
+		return err
+	}
+
+	el, err := election.Load(*electionFile)
+	if err != nil {
+		return err
+	}
+
+	st, err := store.NewBoltStore(*storePath, store.ReadOnly())
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	subs, err := st.Submissions(context.Background(), "")
+	if err != nil {
+		return err
+	}
+	subs = filter.Submissions(subs)
+
+	if *output == "" {
+		return write(st, el, subs, *data, *format, os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := write(st, el, subs, *data, *format, f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func write(st store.Store, el *election.Election, subs []*store.Submission, data, format string, w io.Writer) error {
+	switch data {
+	case "tally":
+		res := export.Tally(el, subs)
+		if format == "json" {
+			return export.TallyJSON(w, res)
+		}
+		return export.TallyCSV(w, el, res)
+	case "submissions":
+		if format == "json" {
+			return export.SubmissionsJSON(w, subs)
+		}
+		return export.SubmissionsCSV(w, subs)
+	case "verdicts":
+		verdicts, err := st.AllVerdicts(context.Background())
+		if err != nil {
+			return err
+		}
+		verdicts = export.Verdicts(subs, verdicts)
+		if format == "json" {
+			return export.VerdictsJSON(w, verdicts)
+		}
+		return export.VerdictsCSV(w, verdicts)
+	}
+	return fmt.Errorf("unknown data %q", data)
+}
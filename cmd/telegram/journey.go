@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"vybar/fsm"
+	"vybar/tg/callback"
+	"vybar/tg/chat"
+	"vybar/tg/keyboard"
+	"vybar/tg/message"
+)
+
+// The voter goes from the precinct question to the code, sends a video and
+// confirms it. /cancel brings the voter back to the start from any step. The
+// code is good for videos only while the voter waits for one: /cancel and
+// the video timeout revoke it.
+const (
+	stateIdle     = fsm.Initial
+	statePrecinct = "precinct"
+	stateVideo    = "video"
+	stateConfirm  = "confirm"
+)
+
+const (
+	confirmPrefix = "confirm"
+	confirmYes    = "confirm:yes"
+	confirmNo     = "confirm:no"
+)
+
+// pendingVideo is a video waiting for the voter's confirmation.
+type pendingVideo struct {
+	ballotVideo
+	MessageID int `json:"message_id"`
+}
+
+func newJourney(st fsm.Store, cfg Config) *fsm.Machine {
+	m := fsm.New(st)
+	m.Allow(stateIdle, statePrecinct, stateVideo)
+	m.Define(statePrecinct, cfg.PrecinctTimeout, stateIdle, statePrecinct, stateVideo)
+	m.Define(stateVideo, cfg.VideoTimeout, stateIdle, statePrecinct, stateVideo, stateConfirm)
+	m.Define(stateConfirm, cfg.ConfirmTimeout, stateVideo, statePrecinct, stateVideo, stateConfirm)
+	return m
+}
+
+// waitsVideo reports whether the voter may send a video in the state.
+func waitsVideo(state string) bool {
+	return state == stateVideo || state == stateConfirm
+}
+
+func (tg *TGBot) processCancel(ctx context.Context, chatID int64) error {
+	if err := tg.journey.Reset(ctx, chatID); err != nil {
+		return err
+	}
+	if err := tg.revokeCode(ctx, chatID); err != nil {
+		return err
+	}
+	msg := message.Text(chatID, fmt.Sprintf("Хорошо, начнем сначала. Когда будешь на участке, нажми «%s»", txtVote))
+	_, err := tg.api.SendMessageContext(ctx, msg)
+	return err
+}
+
+// processStrayText reminds the voter what the bot is waiting for.
+func (tg *TGBot) processStrayText(ctx context.Context, msg *message.Message) error {
+	if msg.Chat.Type != chat.TypePrivate {
+		return nil
+	}
+	st, expired, err := tg.journey.Current(ctx, msg.Chat.ID)
+	if err != nil {
+		return err
+	}
+	var text string
+	switch st.Name {
+	case stateVideo:
+		text = "Жду видео твоего бюллетеня. Чтобы начать сначала, отправь /cancel"
+	case stateConfirm:
+		text = "Подтверди видео кнопками под ним или пришли другое. Чтобы начать сначала, отправь /cancel"
+	default:
+		text = fmt.Sprintf("Когда будешь на участке, нажми «%s». А если хочешь помочь в подсчете, нажми «%s»", txtVote, txtVolunteer)
+		if expired != "" {
+			text = "Время ожидания истекло. " + text
+		}
+	}
+	respMsg := message.Text(msg.Chat.ID, text, message.InReplyTo(msg.ID))
+	_, err = tg.api.SendMessageContext(ctx, respMsg)
+	return err
+}
+
+func (tg *TGBot) askConfirmation(ctx context.Context, msg *message.Message) error {
+	respMsg := message.Text(
+		msg.Chat.ID,
+		"На видео хорошо виден бюллетень с обеих сторон и твой код? Если да, отправь его на проверку",
+		message.InReplyTo(msg.ID),
+		message.WithKeyboard(keyboard.NewInlineMarkup(
+			keyboard.InlineRow(
+				keyboard.CallbackButton("✅ Отправить", confirmYes),
+				keyboard.CallbackButton("🔄 Переснять", confirmNo),
+			),
+		)),
+	)
+	_, err := tg.api.SendMessageContext(ctx, respMsg)
+	return err
+}
+
+func (tg *TGBot) processConfirmation(ctx context.Context, q *callback.Query) error {
+	if q.Message == nil {
+		return tg.api.AnswerCallbackQueryContext(ctx, q.ID, "", false)
+	}
+	chatID := q.Message.Chat.ID
+	st, _, err := tg.journey.Current(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	var video pendingVideo
+	if st.Name != stateConfirm || fsm.Decode(st, &video) != nil {
+		if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Это видео уже не ждет подтверждения", false); err != nil {
+			return err
+		}
+		return tg.removeKeyboard(ctx, q)
+	}
+
+	switch *q.Data {
+	case confirmYes:
+		if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Отправляем видео", false); err != nil {
+			return err
+		}
+		if err := tg.removeKeyboard(ctx, q); err != nil {
+			return err
+		}
+		return tg.submitVideo(ctx, chatID, &video)
+	case confirmNo:
+		if _, err := tg.journey.Transition(ctx, chatID, stateVideo, nil); err != nil && !errors.Is(err, fsm.ErrTransition) {
+			return err
+		}
+		if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, "", false); err != nil {
+			return err
+		}
+		_, err := tg.api.EditMessageTextContext(ctx, message.EditText(chatID, q.Message.ID, "Хорошо, пришли другое видео"))
+		return err
+	}
+	return tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Неизвестная команда", false)
+}
@@ -2,25 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 	"vybar/destenation"
+	"vybar/election"
+	"vybar/fsm"
+	"vybar/moderation"
+	"vybar/store"
 	"vybar/symbol"
 	"vybar/tg"
+	"vybar/tg/chat"
 	"vybar/tg/file"
 	"vybar/tg/keyboard"
 	"vybar/tg/message"
 
 	"github.com/kelseyhightower/envconfig"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/sirupsen/logrus"
 )
 
+// flushTimeout limits delivery of queued messages on shutdown, after
+// handlers had their time to finish.
+const flushTimeout = 10 * time.Second
+
 const (
 	txtVote      = "Иду на участок!"
 	txtVolunteer = "Хочу помочь в подсчете!"
@@ -28,9 +38,58 @@ const (
 
 type Config struct {
 	TelegramToken string `envconfig:"TELEGRAM_TOKEN" required:"true"`
-	Verbose       bool   `envconfig:"VERBOSE"`
-	StorageType   string `envconfig:"STORAGE_TYPE" required:"true"`
-	SecretKey     string `envconfig:"SECRET_KEY" required:"true"`
+	TelegramAPI   string `envconfig:"TELEGRAM_API_URL" default:"https://api.telegram.org"`
+	ServerFiles   string `envconfig:"TELEGRAM_SERVER_FILES"`
+	LocalFiles    string `envconfig:"TELEGRAM_LOCAL_FILES"`
+
+	TelegramProxy   string        `envconfig:"TELEGRAM_PROXY"`
+	TelegramMirrors []string      `envconfig:"TELEGRAM_MIRRORS"`
+	ConnectTimeout  time.Duration `envconfig:"TELEGRAM_CONNECT_TIMEOUT" default:"10s"`
+
+	RequestTimeout time.Duration `envconfig:"TELEGRAM_TIMEOUT" default:"30s"`
+	FileTimeout    time.Duration `envconfig:"TELEGRAM_FILE_TIMEOUT" default:"10m"`
+
+	Verbose      bool   `envconfig:"VERBOSE"`
+	StorageType  string `envconfig:"STORAGE_TYPE" required:"true"`
+	SecretKey    string `envconfig:"SECRET_KEY" required:"true"`
+	StorePath    string `envconfig:"STORE_PATH" default:"vybar.db"`
+	ElectionFile string `envconfig:"ELECTION_FILE" required:"true"`
+
+	// codes issued in one mode are not found in the other one
+	CodesPerPrecinct bool `envconfig:"CODES_PER_PRECINCT"`
+
+	ModerationLease     time.Duration `envconfig:"MODERATION_LEASE" default:"10m"`
+	ModerationQuorum    int           `envconfig:"MODERATION_QUORUM" default:"2"`
+	ModerationReviewers int           `envconfig:"MODERATION_REVIEWERS" default:"3"`
+	Coordinators        []int64       `envconfig:"COORDINATORS"`
+
+	UpdatesMode    string        `envconfig:"UPDATES_MODE" default:"polling"`
+	PollTimeout    time.Duration `envconfig:"POLL_TIMEOUT" default:"30s"`
+	AllowedUpdates []string      `envconfig:"ALLOWED_UPDATES" default:"message,edited_message,callback_query,my_chat_member"`
+	UpdatesRate    float64       `envconfig:"UPDATES_RATE" default:"1"`
+	UpdatesBurst   int           `envconfig:"UPDATES_BURST" default:"10"`
+	Workers        int           `envconfig:"WORKERS" default:"8"`
+	UpdatesBacklog int           `envconfig:"UPDATES_BACKLOG" default:"100"`
+
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"30s"`
+
+	PrecinctTimeout time.Duration `envconfig:"PRECINCT_TIMEOUT" default:"30m"`
+	VideoTimeout    time.Duration `envconfig:"VIDEO_TIMEOUT" default:"12h"`
+	ConfirmTimeout  time.Duration `envconfig:"CONFIRM_TIMEOUT" default:"30m"`
+
+	SendRate      float64 `envconfig:"SEND_RATE" default:"30"`
+	SendChatRate  float64 `envconfig:"SEND_CHAT_RATE" default:"1"`
+	SendChatBurst int     `envconfig:"SEND_CHAT_BURST" default:"3"`
+}
+
+type WebhookParams struct {
+	URL        string `envconfig:"URL" required:"true"`
+	Listen     string `envconfig:"LISTEN" default:":8443"`
+	Path       string `envconfig:"PATH" default:"/telegram"`
+	Secret     string `envconfig:"SECRET" required:"true"`
+	Cert       string `envconfig:"CERT"`
+	Key        string `envconfig:"KEY"`
+	SelfSigned bool   `envconfig:"SELF_SIGNED"`
 }
 
 type FSStorageParams struct {
@@ -46,6 +105,7 @@ type S3BaseStorageParams struct {
 	Key    string `envconfig:"KEY" required:"true"`
 	Secret string `envconfig:"SECRET" required:"true"`
 	Bucket string `envconfig:"BUCKET" required:"true"`
+	Prefix string `envconfig:"PREFIX" default:"ballots/"`
 }
 
 type S3StorageParams struct {
@@ -64,6 +124,11 @@ func main() {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
+	el, err := election.Load(cfg.ElectionFile)
+	if err != nil {
+		panic(err)
+	}
+
 	var dst destenation.Destenation
 	switch cfg.StorageType {
 	case "file":
@@ -86,10 +151,15 @@ func main() {
 			panic(err)
 		}
 
-		d, err := destenation.NewS3Destenation(params.Bucket, params.Key, params.Secret, "us-east-1", destenation.WithCustomEndpoint(params.Endpoint))
+		d, err := destenation.NewS3Destenation(
+			params.Bucket, params.Key, params.Secret, "us-east-1",
+			destenation.WithCustomEndpoint(params.Endpoint),
+			destenation.WithKeyPrefix(params.Prefix),
+		)
 		if err != nil {
 			panic(err)
 		}
+		abortIncompleteUploads(d)
 		dst = d
 	case "s3":
 		var params S3StorageParams
@@ -97,131 +167,306 @@ func main() {
 			panic(err)
 		}
 
-		d, err := destenation.NewS3Destenation(params.Bucket, params.Key, params.Secret, params.Region)
+		d, err := destenation.NewS3Destenation(
+			params.Bucket, params.Key, params.Secret, params.Region,
+			destenation.WithKeyPrefix(params.Prefix),
+		)
 		if err != nil {
 			panic(err)
 		}
+		abortIncompleteUploads(d)
 		dst = d
 	}
 
-	api, err := tg.New(cfg.TelegramToken)
+	st, err := store.NewBoltStore(cfg.StorePath)
 	if err != nil {
 		panic(err)
 	}
+	defer st.Close()
+
+	api, err := tg.New(
+		cfg.TelegramToken,
+		tg.BaseURL(cfg.TelegramAPI),
+		tg.LocalFiles(cfg.ServerFiles, cfg.LocalFiles),
+		tg.Proxy(cfg.TelegramProxy),
+		tg.Mirrors(cfg.TelegramMirrors...),
+		tg.ConnectTimeout(cfg.ConnectTimeout),
+		tg.Timeouts(cfg.RequestTimeout, cfg.FileTimeout),
+		tg.PollTimeout(cfg.PollTimeout),
+		tg.AllowedUpdates(cfg.AllowedUpdates...),
+		tg.WithOffsetStore(st),
+		tg.KeepUpdates(st),
+		tg.SendRate(cfg.SendRate, cfg.SendChatRate, cfg.SendChatBurst),
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer api.Close()
 
+	// ctx is for handlers and outlives polling, so they can finish what
+	// they started after updates stop coming in
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	defer stopPolling()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGABRT)
 
+	drained := make(chan struct{})
 	go func() {
-		defer cancel()
 		<-sigChan
 		logrus.Info("Shutdown an app")
+		stopPolling()
+		select {
+		case <-time.After(cfg.ShutdownTimeout):
+		case <-drained:
+			return
+		}
+
+		// no new replies are taken, those already queued or being sent are
+		// delivered before the handlers which wait for them are cancelled;
+		// updates whose replies were refused are kept for the next run
+		logrus.Warnf("handlers did not finish in %s, flush replies and cancel them", cfg.ShutdownTimeout)
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancelFlush()
+		if err := api.Flush(flushCtx); err != nil {
+			logrus.Errorf("%d messages were not sent: %s", api.QueueDepth().Total(), err)
+		}
+		cancel()
 	}()
 
-	gen, err := symbol.New(cfg.SecretKey)
+	var genOptions []symbol.Option
+	if cfg.CodesPerPrecinct {
+		genOptions = append(genOptions, symbol.PerPrecinct())
+	}
+	gen, err := symbol.New(cfg.SecretKey, st, genOptions...)
+	if err != nil {
+		panic(err)
+	}
+
+	policy, err := moderation.NewPolicy(cfg.ModerationQuorum, cfg.ModerationReviewers)
+	if err != nil {
+		panic(err)
+	}
+
+	coordinators := make(map[int64]bool, len(cfg.Coordinators))
+	for _, id := range cfg.Coordinators {
+		coordinators[id] = true
+	}
+
+	go reportQueueDepth(pollCtx, api)
+
+	updates, err := updatesChan(pollCtx, api, cfg)
+	if err != nil {
+		panic(err)
+	}
+	pending := newPendingUpdates(st)
+	updates, err = pending.replay(ctx, updates)
 	if err != nil {
 		panic(err)
 	}
 
 	bot := TGBot{
-		api:              api,
-		fileStorage:      dst,
-		secretKey:        cfg.SecretKey,
-		generator:        gen,
-		userSalts:        make(map[int64]string),
-		userLastVideoURL: make(map[int64]string),
+		api:         api,
+		updates:     updates,
+		fileStorage: dst,
+		store:       st,
+		secretKey:   cfg.SecretKey,
+		generator:   gen,
+		election:    el,
+
+		moderationLease: cfg.ModerationLease,
+		policy:          policy,
+		coordinators:    coordinators,
+
+		journey: newJourney(st, cfg),
+
+		updatesRate:    cfg.UpdatesRate,
+		updatesBurst:   cfg.UpdatesBurst,
+		workers:        cfg.Workers,
+		updatesBacklog: cfg.UpdatesBacklog,
+		pending:        pending,
 	}
 	bot.Run(ctx)
-}
 
-type TGBot struct {
-	api              *tg.API
-	fileStorage      destenation.Destenation
-	secretKey        string
-	generator        *symbol.Generator
-	userSalts        map[int64]string
-	userLastVideoURL map[int64]string
+	close(drained)
+	logrus.Info("Stopped")
 }
 
-func (tg *TGBot) Run(ctx context.Context) {
-	ch, err := tg.api.GetUpdatesChan(ctx, 0)
+// abandonedUploadAge is far beyond any upload, so uploads which a previous
+// instance is still finishing during a rolling restart are left alone.
+const abandonedUploadAge = 24 * time.Hour
+
+// abortIncompleteUploads drops uploads a previous run did not finish, e.g.
+// because it was killed in the middle of one.
+func abortIncompleteUploads(d *destenation.S3Destenation) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	n, err := d.AbortIncompleteUploads(ctx, abandonedUploadAge)
 	if err != nil {
-		panic(err)
+		logrus.Errorf("failed to abort incomplete uploads: %s", err)
+		return
 	}
+	if n > 0 {
+		logrus.Infof("aborted %d incomplete uploads", n)
+	}
+}
 
-	for upd := range ch {
-		if upd.Message == nil {
-			continue
+func updatesChan(ctx context.Context, api *tg.API, cfg Config) (<-chan tg.Update, error) {
+	switch cfg.UpdatesMode {
+	case "polling":
+		if err := api.DeleteWebhookContext(ctx, false); err != nil {
+			return nil, err
 		}
-
-		txt := ""
-		if upd.Message.Text != nil {
-			txt = strings.ToLower(strings.TrimSpace(*upd.Message.Text))
+		return api.GetUpdatesChan(ctx, 0)
+	case "webhook":
+		var params WebhookParams
+		if err := envconfig.Process("WEBHOOK", &params); err != nil {
+			return nil, err
 		}
 
-		if txt == "/start" {
-			logrus.Debug("start working with new user")
-			if err := tg.welcomeMessage(upd.Message.Chat.ID); err != nil {
-				logrus.Error(err)
-			}
-			continue
+		whCfg := tg.WebhookConfig{
+			URL:            params.URL,
+			SecretToken:    params.Secret,
+			AllowedUpdates: cfg.AllowedUpdates,
 		}
-
-		if txt == strings.ToLower(txtVote) {
-			if err := tg.processVoteRequest(ctx, upd.Message.Chat.ID); err != nil {
-				logrus.Error(err)
+		if params.SelfSigned {
+			cert, err := os.Open(params.Cert)
+			if err != nil {
+				return nil, err
 			}
-			continue
+			defer cert.Close()
+			whCfg.Certificate = cert
 		}
-
-		if txt == strings.ToLower(txtVolunteer) {
-			if err := tg.processModeration(ctx, upd.Message.Chat.ID); err != nil {
-				logrus.Error(err)
-			}
-			continue
+		if err := api.SetWebhookContext(ctx, whCfg); err != nil {
+			return nil, err
 		}
 
-		if upd.Message.Photo != nil {
-			logrus.Debug("got photo")
-			spew.Dump(upd.Message.Photo)
-			var maxSizeFile *file.PhotoSize
-			maxSize := 0
-			for _, ps := range upd.Message.Photo {
-				s := ps.Width * ps.Height
-				if s > maxSize {
-					maxSize = s
-					maxSizeFile = ps
-				}
-			}
+		return api.ListenWebhook(ctx, tg.WebhookServerConfig{
+			Listen:   params.Listen,
+			Path:     params.Path,
+			Secret:   params.Secret,
+			CertFile: params.Cert,
+			KeyFile:  params.Key,
+		})
+	}
+	return nil, fmt.Errorf("unknown updates mode %q", cfg.UpdatesMode)
+}
 
-			if maxSizeFile != nil {
-				if _, err := tg.storeFile(maxSizeFile.ID, "jpg"); err != nil {
-					logrus.Error(err)
-					continue
-				}
-			}
-		}
+type TGBot struct {
+	api         *tg.API
+	updates     <-chan tg.Update
+	fileStorage destenation.Destenation
+	store       store.Store
+	secretKey   string
+	generator   *symbol.Generator
+	election    *election.Election
+
+	moderationLease time.Duration
+	policy          *moderation.Policy
+	// coordinators is filled before the bot starts and only read by
+	// handlers, so it needs no lock.
+	coordinators map[int64]bool
+
+	journey *fsm.Machine
+
+	updatesRate    float64
+	updatesBurst   int
+	workers        int
+	updatesBacklog int
+	pending        *pendingUpdates
+}
 
-		if upd.Message.Video != nil {
-			if err := tg.processVideoMessage(ctx, upd.Message); err != nil {
-				logrus.Error(err)
-			}
-			continue
+// reportQueueDepth logs the outgoing queue while it is not empty.
+func reportQueueDepth(ctx context.Context, api *tg.API) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+		if d := api.QueueDepth(); d.Total() > 0 {
+			logrus.Infof("send queue: %d interactive, %d bulk", d.Interactive, d.Bulk)
+		}
+	}
+}
+
+// isBlocked and isBadRequest are shortcuts for methods of TGBot, where tg
+// names the bot rather than the package. Plain functions use the package.
+func isBlocked(err error) bool {
+	return tg.IsBlocked(err)
+}
+
+func isBadRequest(err error) bool {
+	return tg.IsBadRequest(err)
+}
+
+// logError reports a failed handler. Users who blocked the bot are expected
+// and do not deserve an error in the log.
+func logError(err error) {
+	if tg.IsBlocked(err) {
+		logrus.Infof("user blocked the bot: %s", err)
+		return
+	}
+	if tg.IsBadRequest(err) {
+		logrus.Errorf("telegram rejected the request: %s", err)
+		return
+	}
+	logrus.Error(err)
+}
+
+// Run handles updates until the channel is closed. Updates which are not
+// handled by the time ctx is done are kept for the next run.
+func (tg *TGBot) Run(ctx context.Context) {
+	newRouter(tg).Run(ctx, tg.updates)
+}
+
+// processChatMember remembers users who blocked the bot.
+func (tg *TGBot) processChatMember(ctx context.Context, upd *chat.MemberUpdated) error {
+	if upd.Chat.Type != chat.TypePrivate {
+		return nil
+	}
+	voter, err := tg.getVoter(ctx, upd.Chat.ID)
+	if err != nil {
+		return err
 	}
+	voter.Blocked = upd.Blocked()
+	if voter.Blocked {
+		logrus.Infof("user %d blocked the bot", upd.Chat.ID)
+	} else {
+		logrus.Infof("user %d unblocked the bot", upd.Chat.ID)
+	}
+	return tg.store.SaveVoter(ctx, voter)
 }
 
 func (tg *TGBot) processVoteRequest(ctx context.Context, chatID int64) error {
+	if !tg.election.IsOpen(time.Now()) {
+		msg := message.Text(chatID, "Сейчас голосование не проводится, коды выдаются только во время голосования")
+		_, err := tg.api.SendMessageContext(ctx, msg)
+		return err
+	}
+
+	voter, err := tg.getVoter(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if len(tg.election.Precincts) == 0 {
+		return tg.issueCode(ctx, voter, "")
+	}
+	return tg.askPrecinct(ctx, voter)
+}
+
+func (tg *TGBot) issueCode(ctx context.Context, voter *store.Voter, precinct string) error {
 	logrus.Debug("generator")
-	id, err := tg.generator.Generate()
+	code, err := tg.generator.Generate(ctx, voter.ChatID, tg.election.ID, precinct)
 	if err != nil {
 		return err
 	}
 	msg := message.Text(
-		chatID,
+		voter.ChatID,
 		fmt.Sprintf(
 			`Взяв бюллетень и зайдя в кабинку, возьми свой телефон и включи видеозапись.
 Камеру направь на бюллетень, снимать нужно только его.
@@ -232,33 +477,257 @@ func (tg *TGBot) processVoteRequest(ctx context.Context, chatID int64) error {
 Обязательно загрузи видео сюда, сделать это можно в любое время. Однако чем раньше, тем лучше.
 Спасибо!
 			`,
-			id,
+			code.Code,
 		),
 	)
-	if _, err := tg.api.SendMessage(msg); err != nil {
+	sent, err := tg.api.SendMessageContext(ctx, msg)
+	if err != nil {
 		return err
 	}
-	tg.userSalts[chatID] = id
-	return nil
+
+	voter.Code = code.Code
+	voter.CodeMessageID = sent.ID
+	voter.Precinct = precinct
+	if err := tg.store.SaveVoter(ctx, voter); err != nil {
+		return err
+	}
+	_, err = tg.journey.Transition(ctx, voter.ChatID, stateVideo, nil)
+	return err
 }
 
-func (tg *TGBot) processVideoMessage(ctx context.Context, msg *message.Message) error {
-	logrus.Debug("got video")
-	spew.Dump(msg.Video)
-	p, err := tg.storeFile(msg.Video.ID, "mp4")
+// activeCode returns the code issued to the voter in the current election
+// or nil if there is none.
+func (tg *TGBot) activeCode(ctx context.Context, chatID int64) (*store.Code, error) {
+	voter, err := tg.store.GetVoter(ctx, chatID)
 	if err != nil {
-		respMsg := message.Text(
-			msg.Chat.ID, "При загрузке видео произошла ошибка, попробуйте еще раз",
-			message.InReplyTo(msg.ID),
-		)
-		if _, err := tg.api.SendMessage(respMsg); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if voter.Code == "" {
+		return nil, nil
+	}
+	code, err := tg.generator.Verify(ctx, tg.election.ID, voter.Precinct, voter.Code)
+	if err != nil {
+		if errors.Is(err, symbol.ErrUnknownCode) || errors.Is(err, symbol.ErrInvalidCode) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if code.ChatID != chatID {
+		return nil, nil
+	}
+	return code, nil
+}
+
+const txtCodeUsed = "С этим кодом видео уже отправлено, по одному коду принимается только один бюллетень"
+
+// codeUsed reports whether a video was already submitted with the code.
+func (tg *TGBot) codeUsed(ctx context.Context, code *store.Code) (bool, error) {
+	_, err := tg.store.SubmissionByCode(ctx, code.Precinct, code.Code)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// revokeCode takes the code away from the voter, so no video is accepted
+// with it any more.
+func (tg *TGBot) revokeCode(ctx context.Context, chatID int64) error {
+	voter, err := tg.store.GetVoter(ctx, chatID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if voter.Code == "" {
+		return nil
+	}
+	voter.Code = ""
+	return tg.store.SaveVoter(ctx, voter)
+}
+
+// ballotVideo is a video received from a voter as a video, a round video
+// note, an animation (telegram turns short videos without sound into them)
+// or a file.
+type ballotVideo struct {
+	file.FileBase
+	Duration int    `json:"duration,omitempty"`
+	Ext      string `json:"ext"`
+	Media    string `json:"media,omitempty"`
+}
+
+var videoExtensions = map[string]string{
+	"video/mp4":        "mp4",
+	"video/quicktime":  "mov",
+	"video/webm":       "webm",
+	"video/x-matroska": "mkv",
+	"video/3gpp":       "3gp",
+	"video/x-msvideo":  "avi",
+}
+
+func videoExt(name, mimeType *string) string {
+	if name != nil {
+		if ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(*name)), "."); ext != "" {
+			return ext
+		}
+	}
+	if mimeType != nil {
+		if ext, ok := videoExtensions[*mimeType]; ok {
+			return ext
+		}
+	}
+	return "mp4"
+}
+
+func videoOf(msg *message.Message) (*ballotVideo, bool) {
+	switch {
+	case msg.Video != nil:
+		return &ballotVideo{
+			FileBase: msg.Video.FileBase,
+			Media:    message.MediaVideo,
+			Duration: msg.Video.Duration,
+			Ext:      videoExt(msg.Video.FileName, msg.Video.MimeType),
+		}, true
+	case msg.VideoNote != nil:
+		return &ballotVideo{
+			FileBase: msg.VideoNote.FileBase,
+			Media:    message.MediaVideoNote,
+			Duration: msg.VideoNote.Duration,
+			Ext:      "mp4",
+		}, true
+	case msg.Animation != nil:
+		return &ballotVideo{
+			FileBase: msg.Animation.FileBase,
+			Media:    message.MediaAnimation,
+			Duration: msg.Animation.Duration,
+			Ext:      videoExt(msg.Animation.FileName, msg.Animation.MimeType),
+		}, true
+	case msg.Document != nil && msg.Document.IsVideo():
+		return &ballotVideo{
+			FileBase: msg.Document.FileBase,
+			Media:    message.MediaDocument,
+			Ext:      videoExt(msg.Document.FileName, msg.Document.MimeType),
+		}, true
+	}
+	return nil, false
+}
+
+// processVideoMessage takes a video from a voter who was given a code and
+// asks to confirm it before it goes to moderation.
+func (tg *TGBot) processVideoMessage(ctx context.Context, msg *message.Message, video *ballotVideo) error {
+	logrus.Debugf("got %s %s from %d, %d s", video.Media, video.FileUniqueID, msg.Chat.ID, video.Duration)
+	reply := func(text string) error {
+		respMsg := message.Text(msg.Chat.ID, text, message.InReplyTo(msg.ID))
+		_, err := tg.api.SendMessageContext(ctx, respMsg)
+		return err
+	}
+
+	st, expired, err := tg.journey.Current(ctx, msg.Chat.ID)
+	if err != nil {
+		return err
+	}
+	if !waitsVideo(st.Name) {
+		if !waitsVideo(expired) {
+			return reply(fmt.Sprintf("Чтобы отправить видео, сначала получи код: нажми «%s»", txtVote))
+		}
+		if err := tg.revokeCode(ctx, msg.Chat.ID); err != nil {
 			return err
 		}
+		return reply(fmt.Sprintf("Время ожидания видео истекло. Чтобы отправить видео, получи новый код: нажми «%s»", txtVote))
+	}
+	code, err := tg.activeCode(ctx, msg.Chat.ID)
+	if err != nil {
+		return err
+	}
+	if code == nil {
+		return reply(fmt.Sprintf("Чтобы отправить видео, сначала получи код: нажми «%s»", txtVote))
+	}
+	used, err := tg.codeUsed(ctx, code)
+	if err != nil {
+		return err
+	}
+	if used {
+		return reply(txtCodeUsed)
+	}
+	if _, err := tg.store.SubmissionByFile(ctx, video.FileUniqueID); err == nil {
+		return reply("Это видео уже было отправлено")
+	} else if !errors.Is(err, store.ErrNotFound) {
 		return err
 	}
+
+	pending := pendingVideo{
+		ballotVideo: *video,
+		MessageID:   msg.ID,
+	}
+	if _, err := tg.journey.Transition(ctx, msg.Chat.ID, stateConfirm, &pending); err != nil {
+		return err
+	}
+	return tg.askConfirmation(ctx, msg)
+}
+
+// submitVideo stores the confirmed video and sends it to moderation.
+func (tg *TGBot) submitVideo(ctx context.Context, chatID int64, video *pendingVideo) error {
+	reply := func(text string, options ...message.Option) error {
+		respMsg := message.Text(chatID, text, append([]message.Option{message.InReplyTo(video.MessageID)}, options...)...)
+		_, err := tg.api.SendMessageContext(ctx, respMsg)
+		return err
+	}
+
+	code, err := tg.activeCode(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if code == nil {
+		return reply(fmt.Sprintf("Чтобы отправить видео, сначала получи код: нажми «%s»", txtVote))
+	}
+
+	p, err := tg.storeFile(ctx, video.ID, video.Ext)
+	if err != nil {
+		if err := reply("При загрузке видео произошла ошибка, попробуйте еще раз"); err != nil {
+			return err
+		}
+		return err
+	}
+	sub := store.Submission{
+		ChatID:       chatID,
+		Code:         code.Code,
+		Precinct:     code.Precinct,
+		FileID:       video.ID,
+		FileUniqueID: video.FileUniqueID,
+		Media:        video.Media,
+		Duration:     video.Duration,
+		Path:         p,
+		Status:       store.StatusPending,
+	}
+	if video.FileSize != nil {
+		sub.Size = *video.FileSize
+	}
+	if err := tg.store.SaveSubmission(ctx, &sub); err != nil {
+		if !errors.Is(err, store.ErrExists) {
+			return err
+		}
+		// the same video or another one with the code was sent meanwhile
+		used, err := tg.codeUsed(ctx, code)
+		if err != nil {
+			return err
+		}
+		if used {
+			return reply(txtCodeUsed)
+		}
+		return reply("Это видео уже было отправлено")
+	}
+	if err := tg.journey.Reset(ctx, chatID); err != nil {
+		return err
+	}
+	if err := tg.deleteCodeMessage(ctx, chatID); err != nil {
+		logrus.Errorf("failed to delete code message: %s", err)
+	}
 	msgText := "Ваше видео успешно принято"
 	s3dst, ok := tg.fileStorage.(*destenation.S3Destenation)
-	options := []message.Option{message.InReplyTo(msg.ID)}
+	options := make([]message.Option, 0)
 	if ok {
 		u, err := s3dst.PublicURL(ctx, p)
 		if err != nil {
@@ -276,23 +745,37 @@ func (tg *TGBot) processVideoMessage(ctx context.Context, msg *message.Message)
 				},
 			},
 		))
-		tg.userLastVideoURL[msg.Chat.ID] = u
 	}
-	respMsg := message.Text(msg.Chat.ID, msgText, options...)
-	if _, err := tg.api.SendMessage(respMsg); err != nil {
+	return reply(msgText, options...)
+}
+
+// deleteCodeMessage removes the instruction with the secret code once the
+// video is received, so the code does not stay in the chat history.
+func (tg *TGBot) deleteCodeMessage(ctx context.Context, chatID int64) error {
+	voter, err := tg.store.GetVoter(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if voter.CodeMessageID == 0 {
+		return nil
+	}
+	err = tg.api.DeleteMessageContext(ctx, chatID, voter.CodeMessageID)
+	if err != nil && !isBadRequest(err) {
 		return err
 	}
-	return nil
+	// telegram refuses to delete old messages, there is no point to retry
+	voter.CodeMessageID = 0
+	return tg.store.SaveVoter(ctx, voter)
 }
 
-func (tg *TGBot) storeFile(fileID string, ext string) (string, error) {
-	rdr, err := tg.api.GetFD(fileID)
+func (tg *TGBot) storeFile(ctx context.Context, fileID string, ext string) (string, error) {
+	rdr, err := tg.api.GetFDContext(ctx, fileID)
 	if err != nil {
 		return "", err
 	}
 	defer rdr.Close()
 
-	p, err := tg.fileStorage.Store(context.Background(), rdr, ext)
+	p, err := tg.fileStorage.Store(ctx, rdr, ext)
 	if err != nil {
 		return "", err
 	}
@@ -303,11 +786,11 @@ func (tg *TGBot) storeFile(fileID string, ext string) (string, error) {
 	return p, nil
 }
 
-func (tg *TGBot) welcomeMessage(chatID int64) error {
+func (tg *TGBot) welcomeMessage(ctx context.Context, chatID int64) error {
 	msg := message.Text(
 		chatID, "Привет! Я знаю, как ты можешь защитить свой голос",
 	)
-	if _, err := tg.api.SendMessage(msg); err != nil {
+	if _, err := tg.api.SendMessageContext(ctx, msg); err != nil {
 		return err
 	}
 
@@ -315,7 +798,7 @@ func (tg *TGBot) welcomeMessage(chatID int64) error {
 		chatID,
 		"План очень прост! Тебе нужно лишь написать мне перед тем, как пойдешь на участок и я скажу тебе, что надо делать",
 	)
-	if _, err := tg.api.SendMessage(msg); err != nil {
+	if _, err := tg.api.SendMessageContext(ctx, msg); err != nil {
 		return err
 	}
 
@@ -330,97 +813,7 @@ func (tg *TGBot) welcomeMessage(chatID int64) error {
 			),
 		),
 	)
-	if _, err := tg.api.SendMessage(msg); err != nil {
-		return err
-	}
-	return nil
-}
-
-func escape(s string) string {
-	return strings.ReplaceAll(s, `\`, `\\`)
-}
-
-func (tg *TGBot) processModeration(ctx context.Context, chatID int64) error {
-	msg := message.Text(chatID, "Спасибо что согласился помочь!")
-	if _, err := tg.api.SendMessage(msg); err != nil {
-		return err
-	}
-	noVideo := func() error {
-		msg := message.Text(chatID, "На данный момент у нас нет видео для валидации")
-		if _, err := tg.api.SendMessage(msg); err != nil {
-			return err
-		}
-		return nil
-	}
-
-	salt, ok := tg.userSalts[chatID]
-	if !ok {
-		return noVideo()
-	}
-	video, ok := tg.userLastVideoURL[chatID]
-	if !ok {
-		return noVideo()
-	}
-
-	msg = message.Text(
-		chatID,
-		fmt.Sprintf(`Пожалуйста, посмотри это [видео](%s) и убедись в следующих фактах:
-
-\* В этом видео видно бюллетень с двух сторон
-\* На этом бюллетене есть минимум две подписи членов избирательной комиссии
-\* В бюллетене отмечен только один кандидат
-\* Для отметки использовались символы: %s
-\* Ответь ниже, за какого кандидата поставлена отметка, либо сообщи, что видео не соответствует требованиям
-`, video, fmt.Sprintf("```%s```", escape(salt))),
-		message.Markdown(),
-		message.WithKeyboard(&keyboard.InlineMarkup{
-			Buttons: [][]keyboard.InlineButton{
-				{
-					{
-						Text: "Кандидат 1",
-						URL:  video,
-					},
-				},
-				{
-					{
-						Text: "Кандидат 2",
-						URL:  video,
-					},
-				},
-				{
-					{
-						Text: "Кандидат 3",
-						URL:  video,
-					},
-				},
-				{
-					{
-						Text: "Кандидат 4",
-						URL:  video,
-					},
-				},
-				{
-					{
-						Text: "Против всех",
-						URL:  video,
-					},
-				},
-				{
-					{
-						Text: "Бюллетень испорчен",
-						URL:  video,
-					},
-				},
-				{
-					{
-						Text: "Видео не соответствует требованиям",
-						URL:  video,
-					},
-				},
-			},
-		}),
-	)
-	if _, err := tg.api.SendMessage(msg); err != nil {
+	if _, err := tg.api.SendMessageContext(ctx, msg); err != nil {
 		return err
 	}
 	return nil
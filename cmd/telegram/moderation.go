@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"vybar/destenation"
+	"vybar/moderation"
+	"vybar/store"
+	"vybar/tg/callback"
+	"vybar/tg/file"
+	"vybar/tg/keyboard"
+	"vybar/tg/message"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	verdictPrefix = "verdict"
+)
+
+func verdictData(submissionID int64, optionID string) string {
+	return fmt.Sprintf("%s:%d:%s", verdictPrefix, submissionID, optionID)
+}
+
+func parseVerdictData(data string) (int64, string, error) {
+	parts := strings.SplitN(data, ":", 3)
+	if len(parts) != 3 || parts[0] != verdictPrefix {
+		return 0, "", fmt.Errorf("unexpected callback data %q", data)
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return id, parts[2], nil
+}
+
+func escape(s string) string {
+	return strings.ReplaceAll(s, `\`, `\\`)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+func (tg *TGBot) isCoordinator(chatID int64) bool {
+	return tg.coordinators[chatID]
+}
+
+func (tg *TGBot) processModeration(ctx context.Context, chatID int64) error {
+	msg := message.Text(chatID, "Спасибо что согласился помочь!")
+	if _, err := tg.api.SendMessageContext(ctx, msg); err != nil {
+		return err
+	}
+	noVideo := func() error {
+		msg := message.Text(chatID, "На данный момент у нас нет видео для валидации")
+		if _, err := tg.api.SendMessageContext(ctx, msg); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	sub, err := tg.store.Lease(ctx, chatID, tg.policy.Reviewers, tg.moderationLease)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return noVideo()
+		}
+		return err
+	}
+	return tg.sendModerationCard(ctx, chatID, sub, "")
+}
+
+func (tg *TGBot) processEscalated(ctx context.Context, chatID int64) error {
+	subs, err := tg.store.Submissions(ctx, store.StatusEscalated)
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		msg := message.Text(chatID, "Спорных видео нет")
+		_, err := tg.api.SendMessageContext(ctx, msg)
+		return err
+	}
+	for _, sub := range subs {
+		if err := tg.sendEscalation(ctx, chatID, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tg *TGBot) notifyCoordinators(ctx context.Context, sub *store.Submission) error {
+	for chatID := range tg.coordinators {
+		blocked, err := tg.hasBlocked(ctx, chatID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		err = tg.sendEscalation(ctx, chatID, sub, message.Bulk())
+		if isBlocked(err) {
+			logrus.Infof("coordinator %d blocked the bot", chatID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasBlocked reports whether the user is known to have blocked the bot, so
+// messages to them would fail anyway.
+func (tg *TGBot) hasBlocked(ctx context.Context, chatID int64) (bool, error) {
+	voter, err := tg.store.GetVoter(ctx, chatID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return voter.Blocked, nil
+}
+
+func (tg *TGBot) sendEscalation(ctx context.Context, chatID int64, sub *store.Submission, options ...message.Option) error {
+	verdicts, err := tg.store.Verdicts(ctx, sub.ID)
+	if err != nil {
+		return err
+	}
+	header := "*Мнения волонтеров разошлись, нужно твое решение*\n"
+	for _, cnt := range moderation.Count(verdicts) {
+		text := cnt.Option
+		if choice, ok := tg.election.Choice(cnt.Option); ok {
+			text = choice.Title
+		}
+		header += fmt.Sprintf("%s: %d\n", escapeMarkdown(text), cnt.Count)
+	}
+	return tg.sendModerationCard(ctx, chatID, sub, header+"\n", options...)
+}
+
+// sendModerationCard shows the video right in the chat, so moderation works
+// with any storage. A link to the stored copy is added when the storage
+// provides one.
+func (tg *TGBot) sendModerationCard(ctx context.Context, chatID int64, sub *store.Submission, header string, options ...message.Option) error {
+	salt := sub.Code
+
+	rows := make([][]keyboard.InlineButton, 0)
+	if s3dst, ok := tg.fileStorage.(*destenation.S3Destenation); ok && sub.Path != "" {
+		video, err := s3dst.PublicURL(ctx, sub.Path)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, keyboard.InlineRow(keyboard.URLButton("▶️ Посмотреть", video)))
+	}
+	for _, choice := range tg.election.Choices() {
+		rows = append(rows, keyboard.InlineRow(
+			keyboard.CallbackButton(choice.Title, verdictData(sub.ID, choice.ID)),
+		))
+	}
+
+	caption := header + fmt.Sprintf(`Пожалуйста, посмотри это видео и убедись в следующих фактах:
+
+\* В этом видео видно бюллетень с двух сторон
+\* На этом бюллетене есть минимум две подписи членов избирательной комиссии
+\* В бюллетене отмечен только один кандидат
+\* Для отметки использовались символы: %s
+\* Ответь ниже, за какого кандидата поставлена отметка, либо сообщи, что видео не соответствует требованиям
+`, fmt.Sprintf("```%s```", escape(salt)))
+	cardOptions := append([]message.Option{
+		message.Markdown(),
+		message.WithKeyboard(keyboard.NewInlineMarkup(rows...)),
+	}, options...)
+
+	f := file.ByID(sub.FileID)
+	var err error
+	switch sub.Media {
+	case message.MediaDocument:
+		_, err = tg.api.SendDocumentContext(ctx, message.Document(chatID, f, caption, cardOptions...))
+	case message.MediaAnimation:
+		_, err = tg.api.SendAnimationContext(ctx, message.Animation(chatID, f, caption, cardOptions...))
+	case message.MediaVideoNote:
+		// round videos have no caption, the card goes as a reply to it
+		var note *message.Message
+		note, err = tg.api.SendVideoNoteContext(ctx, message.VideoNote(chatID, f, options...))
+		if err != nil {
+			return err
+		}
+		_, err = tg.api.SendMessageContext(ctx, message.Text(chatID, caption, append(cardOptions, message.InReplyTo(note.ID))...))
+	default:
+		_, err = tg.api.SendVideoContext(ctx, message.Video(chatID, f, caption, cardOptions...))
+	}
+	return err
+}
+
+func (tg *TGBot) processVerdict(ctx context.Context, q *callback.Query) error {
+	submissionID, optionID, err := parseVerdictData(*q.Data)
+	if err != nil {
+		if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Неизвестная команда", false); err != nil {
+			return err
+		}
+		return err
+	}
+	choice, ok := tg.election.Choice(optionID)
+	if !ok {
+		if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Неизвестный вариант ответа", false); err != nil {
+			return err
+		}
+		return fmt.Errorf("unknown verdict option %q", optionID)
+	}
+
+	sub, err := tg.store.GetSubmission(ctx, submissionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Это видео больше не доступно", false)
+		}
+		return err
+	}
+
+	reviewerID := int64(q.From.ID)
+	verdict := store.Verdict{
+		SubmissionID: submissionID,
+		ReviewerID:   reviewerID,
+		Option:       choice.ID,
+		Final:        sub.Status == store.StatusEscalated && tg.isCoordinator(reviewerID),
+	}
+	sub, err = tg.store.AddVerdict(ctx, &verdict, tg.policy.Decide)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Это видео больше не доступно", false)
+		case errors.Is(err, store.ErrClosed):
+			if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, "По этому видео уже принято решение", true); err != nil {
+				return err
+			}
+			return tg.closeCard(ctx, q, "По этому видео уже принято решение")
+		case errors.Is(err, store.ErrLeaseLost):
+			if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Время на проверку истекло, видео передано другому волонтеру", true); err != nil {
+				return err
+			}
+			return tg.closeCard(ctx, q, "⌛ Время на проверку истекло")
+		}
+		return err
+	}
+
+	if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, fmt.Sprintf("Спасибо! Ответ учтен: %s", choice.Title), false); err != nil {
+		return err
+	}
+	if err := tg.closeCard(ctx, q, fmt.Sprintf("✅ учтено: %s", choice.Title)); err != nil {
+		return err
+	}
+	if sub.Status == store.StatusEscalated {
+		return tg.notifyCoordinators(ctx, sub)
+	}
+	return nil
+}
+
+// closeCard replaces the text of the moderation card with the outcome, which
+// also removes the keyboard.
+func (tg *TGBot) closeCard(ctx context.Context, q *callback.Query, text string) error {
+	if q.Message == nil {
+		return nil
+	}
+	chatID, messageID := q.Message.Chat.ID, q.Message.ID
+	var err error
+	if q.Message.Text != nil {
+		// cards sent as text before videos were shown in the chat
+		_, err = tg.api.EditMessageTextContext(ctx, message.EditText(chatID, messageID, text))
+	} else {
+		_, err = tg.api.EditMessageCaptionContext(ctx, message.EditCaption(chatID, messageID, text))
+	}
+	return err
+}
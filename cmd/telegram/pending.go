@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"vybar/store"
+	"vybar/tg"
+	"vybar/tg/bot"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pendingUpdates keeps updates which were received but not handled yet. tg
+// saves them before telegram forgets them, they stay in the store until they
+// are handled, so neither a crash nor a shutdown loses them.
+type pendingUpdates struct {
+	store store.Store
+}
+
+func newPendingUpdates(st store.Store) *pendingUpdates {
+	return &pendingUpdates{
+		store: st,
+	}
+}
+
+// replay puts updates left unhandled by the previous run before the new
+// ones. Telegram sends again those it was not told about, they are skipped.
+func (p *pendingUpdates) replay(ctx context.Context, updates <-chan tg.Update) (<-chan tg.Update, error) {
+	pending, err := p.store.PendingUpdates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return updates, nil
+	}
+	logrus.Infof("retry %d updates left from the previous run", len(pending))
+
+	upds := make([]tg.Update, 0, len(pending))
+	last := 0
+	for _, data := range pending {
+		var upd tg.Update
+		if err := json.Unmarshal(data, &upd); err != nil {
+			logrus.Errorf("failed to decode pending update: %s", err)
+			continue
+		}
+		if upd.ID > last {
+			last = upd.ID
+		}
+		upds = append(upds, upd)
+	}
+
+	result := make(chan tg.Update)
+	go func() {
+		defer close(result)
+		for _, upd := range upds {
+			result <- upd
+		}
+		for upd := range updates {
+			if upd.ID <= last {
+				continue
+			}
+			result <- upd
+		}
+	}()
+	return result, nil
+}
+
+// keep saves the update for the next run.
+func (p *pendingUpdates) keep(upd *tg.Update) {
+	data, err := json.Marshal(upd)
+	if err == nil {
+		// ctx is done by now, the update must be saved anyway
+		err = p.store.SavePendingUpdate(context.Background(), upd.ID, data)
+	}
+	if err != nil {
+		logrus.Errorf("failed to keep unhandled update %d: %s", upd.ID, err)
+		return
+	}
+	logrus.Infof("update %d is kept until restart", upd.ID)
+}
+
+// forget is middleware which removes updates from the store once they are
+// handled, successfully or not, so a broken update is not retried forever.
+// Updates which failed because of shutdown are kept.
+func (p *pendingUpdates) forget(next bot.Handler) bot.Handler {
+	return func(ctx context.Context, upd *tg.Update) error {
+		err := next(ctx, upd)
+		if bot.Interrupted(ctx, err) {
+			return err
+		}
+		// ctx may be done already, the handled update must be removed anyway
+		if delErr := p.store.DeletePendingUpdate(context.Background(), upd.ID); delErr != nil {
+			logrus.Errorf("failed to forget pending update %d: %s", upd.ID, delErr)
+		}
+		return err
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"vybar/store"
+	"vybar/tg/callback"
+	"vybar/tg/keyboard"
+	"vybar/tg/message"
+)
+
+const (
+	precinctPrefix   = "precinct"
+	precinctPageSize = 8
+)
+
+func precinctData(args ...interface{}) string {
+	parts := []string{precinctPrefix}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprint(arg))
+	}
+	return strings.Join(parts, ":")
+}
+
+func (tg *TGBot) getVoter(ctx context.Context, chatID int64) (*store.Voter, error) {
+	voter, err := tg.store.GetVoter(ctx, chatID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		voter = &store.Voter{ChatID: chatID}
+	}
+	return voter, nil
+}
+
+func (tg *TGBot) askPrecinct(ctx context.Context, voter *store.Voter) error {
+	if _, err := tg.journey.Transition(ctx, voter.ChatID, statePrecinct, nil); err != nil {
+		return err
+	}
+
+	rows := make([][]keyboard.InlineButton, 0)
+	if voter.Precinct != "" {
+		rows = append(rows, keyboard.InlineRow(
+			keyboard.CallbackButton(fmt.Sprintf("Мой участок №%s", voter.Precinct), precinctData("choose", voter.Precinct)),
+		))
+	}
+	rows = append(rows, tg.regionsKeyboard(0).Buttons...)
+
+	msg := message.Text(
+		voter.ChatID,
+		"На каком участке ты голосуешь? Напиши номер участка или выбери его из списка",
+		message.WithKeyboard(keyboard.NewInlineMarkup(rows...)),
+	)
+	_, err := tg.api.SendMessageContext(ctx, msg)
+	return err
+}
+
+func paginate(n, page int) (int, int) {
+	from := page * precinctPageSize
+	if from > n || from < 0 {
+		from = 0
+	}
+	to := from + precinctPageSize
+	if to > n {
+		to = n
+	}
+	return from, to
+}
+
+func navigationRow(n, page int, data func(page int) string) []keyboard.InlineButton {
+	row := keyboard.InlineRow()
+	if page > 0 {
+		row = append(row, keyboard.CallbackButton("◀️", data(page-1)))
+	}
+	if (page+1)*precinctPageSize < n {
+		row = append(row, keyboard.CallbackButton("▶️", data(page+1)))
+	}
+	return row
+}
+
+func appendRow(rows [][]keyboard.InlineButton, row []keyboard.InlineButton) [][]keyboard.InlineButton {
+	if len(row) == 0 {
+		return rows
+	}
+	return append(rows, row)
+}
+
+func (tg *TGBot) regionsKeyboard(page int) *keyboard.InlineMarkup {
+	regions := tg.election.Regions()
+	from, to := paginate(len(regions), page)
+	rows := make([][]keyboard.InlineButton, 0)
+	for i := from; i < to; i++ {
+		rows = append(rows, keyboard.InlineRow(
+			keyboard.CallbackButton(regions[i], precinctData("region", i, 0)),
+		))
+	}
+	rows = appendRow(rows, navigationRow(len(regions), page, func(p int) string {
+		return precinctData("regions", p)
+	}))
+	return keyboard.NewInlineMarkup(rows...)
+}
+
+func (tg *TGBot) commissionsKeyboard(region, page int) *keyboard.InlineMarkup {
+	regions := tg.election.Regions()
+	if region < 0 || region >= len(regions) {
+		return tg.regionsKeyboard(0)
+	}
+	commissions := tg.election.Commissions(regions[region])
+	from, to := paginate(len(commissions), page)
+	rows := make([][]keyboard.InlineButton, 0)
+	for i := from; i < to; i++ {
+		rows = append(rows, keyboard.InlineRow(
+			keyboard.CallbackButton(commissions[i], precinctData("commission", region, i, 0)),
+		))
+	}
+	rows = appendRow(rows, navigationRow(len(commissions), page, func(p int) string {
+		return precinctData("region", region, p)
+	}))
+	rows = append(rows, keyboard.InlineRow(keyboard.CallbackButton("⬅️ К списку регионов", precinctData("regions", 0))))
+	return keyboard.NewInlineMarkup(rows...)
+}
+
+func (tg *TGBot) precinctsKeyboard(region, commission, page int) *keyboard.InlineMarkup {
+	regions := tg.election.Regions()
+	if region < 0 || region >= len(regions) {
+		return tg.regionsKeyboard(0)
+	}
+	commissions := tg.election.Commissions(regions[region])
+	if commission < 0 || commission >= len(commissions) {
+		return tg.commissionsKeyboard(region, 0)
+	}
+	precincts := tg.election.PrecinctsOf(regions[region], commissions[commission])
+	from, to := paginate(len(precincts), page)
+	rows := make([][]keyboard.InlineButton, 0)
+	for _, p := range precincts[from:to] {
+		text := fmt.Sprintf("№%s", p.ID)
+		if p.Address != "" {
+			text = fmt.Sprintf("№%s, %s", p.ID, p.Address)
+		}
+		rows = append(rows, keyboard.InlineRow(
+			keyboard.CallbackButton(text, precinctData("choose", p.ID)),
+		))
+	}
+	rows = appendRow(rows, navigationRow(len(precincts), page, func(p int) string {
+		return precinctData("commission", region, commission, p)
+	}))
+	rows = append(rows, keyboard.InlineRow(keyboard.CallbackButton("⬅️ К списку комиссий", precinctData("region", region, 0))))
+	return keyboard.NewInlineMarkup(rows...)
+}
+
+func (tg *TGBot) processPrecinctCallback(ctx context.Context, q *callback.Query) error {
+	parts := strings.Split(*q.Data, ":")
+	if len(parts) < 3 || q.Message == nil {
+		return tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Неизвестная команда", false)
+	}
+
+	if parts[1] == "choose" {
+		return tg.choosePrecinct(ctx, q, strings.Join(parts[2:], ":"))
+	}
+
+	args := make([]int, 0, len(parts)-2)
+	for _, p := range parts[2:] {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Неизвестная команда", false)
+		}
+		args = append(args, n)
+	}
+
+	var kb *keyboard.InlineMarkup
+	switch {
+	case parts[1] == "regions" && len(args) == 1:
+		kb = tg.regionsKeyboard(args[0])
+	case parts[1] == "region" && len(args) == 2:
+		kb = tg.commissionsKeyboard(args[0], args[1])
+	case parts[1] == "commission" && len(args) == 3:
+		kb = tg.precinctsKeyboard(args[0], args[1], args[2])
+	default:
+		return tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Неизвестная команда", false)
+	}
+
+	if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, "", false); err != nil {
+		return err
+	}
+	_, err := tg.api.EditMessageReplyMarkupContext(ctx, message.EditMarkup(q.Message.Chat.ID, q.Message.ID, kb))
+	return err
+}
+
+func (tg *TGBot) choosePrecinct(ctx context.Context, q *callback.Query, precinct string) error {
+	voter, err := tg.getVoter(ctx, q.Message.Chat.ID)
+	if err != nil {
+		return err
+	}
+	st, _, err := tg.journey.Current(ctx, voter.ChatID)
+	if err != nil {
+		return err
+	}
+	if st.Name != statePrecinct {
+		if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Чтобы получить код, нажми «"+txtVote+"»", true); err != nil {
+			return err
+		}
+		return tg.removeKeyboard(ctx, q)
+	}
+	if _, ok := tg.election.Precinct(precinct); !ok {
+		return tg.api.AnswerCallbackQueryContext(ctx, q.ID, "Участок не найден", true)
+	}
+
+	if err := tg.api.AnswerCallbackQueryContext(ctx, q.ID, fmt.Sprintf("Участок №%s", precinct), false); err != nil {
+		return err
+	}
+	if err := tg.removeKeyboard(ctx, q); err != nil {
+		return err
+	}
+	return tg.issueCode(ctx, voter, precinct)
+}
+
+// processPrecinctText handles a precinct number typed by a voter who was
+// asked for it. It reports whether the message was handled.
+func (tg *TGBot) processPrecinctText(ctx context.Context, msg *message.Message) (bool, error) {
+	st, _, err := tg.journey.Current(ctx, msg.Chat.ID)
+	if err != nil {
+		return false, err
+	}
+	if st.Name != statePrecinct {
+		return false, nil
+	}
+	voter, err := tg.getVoter(ctx, msg.Chat.ID)
+	if err != nil {
+		return false, err
+	}
+
+	precinct := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(*msg.Text), "№#"))
+	if _, ok := tg.election.Precinct(precinct); !ok {
+		respMsg := message.Text(
+			msg.Chat.ID, "Такой участок не найден, проверь номер или выбери участок из списка",
+			message.InReplyTo(msg.ID),
+		)
+		_, err := tg.api.SendMessageContext(ctx, respMsg)
+		return true, err
+	}
+	return true, tg.issueCode(ctx, voter, precinct)
+}
+
+func (tg *TGBot) removeKeyboard(ctx context.Context, q *callback.Query) error {
+	if q.Message == nil {
+		return nil
+	}
+	_, err := tg.api.EditMessageReplyMarkupContext(ctx, message.EditMarkup(q.Message.Chat.ID, q.Message.ID, nil))
+	return err
+}
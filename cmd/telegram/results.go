@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"vybar/election"
+	"vybar/tally"
+	"vybar/tg/message"
+)
+
+func (tg *TGBot) processResults(ctx context.Context, chatID int64, precinct string) error {
+	res, err := tally.Compute(ctx, tg.store, tg.election)
+	if err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("Итоги: %s", tg.election.Title)
+	counts := res.Overall
+	if precinct != "" {
+		title = fmt.Sprintf("Итоги по участку %s", precinct)
+		counts = res.Precinct(precinct)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\nна %s\n\n", title, res.At.Format("02.01.2006 15:04:05"))
+	for _, choice := range tg.election.Choices() {
+		if choice.Kind == election.KindSpoiled || choice.Kind == election.KindInvalid {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %d\n", choice.Title, counts.Votes[choice.ID])
+	}
+	fmt.Fprintf(&b, "\nУчтено голосов: %d\n", counts.Accepted())
+	fmt.Fprintf(&b, "Испорчено бюллетеней: %d\n", counts.Spoiled)
+	fmt.Fprintf(&b, "Отклонено видео: %d\n", counts.Rejected)
+	if counts.Unknown > 0 {
+		fmt.Fprintf(&b, "С неизвестным вариантом: %d\n", counts.Unknown)
+	}
+	fmt.Fprintf(&b, "Ожидают проверки: %d\n", counts.Pending)
+	fmt.Fprintf(&b, "Спорных: %d\n", counts.Escalated)
+	if counts.Duplicates > 0 {
+		fmt.Fprintf(&b, "Повторных по одному коду: %d\n", counts.Duplicates)
+	}
+	fmt.Fprintf(&b, "Всего видео: %d\n", counts.Total)
+	if precinct == "" {
+		fmt.Fprintf(&b, "Участков с видео: %d\n", len(res.Precincts))
+	}
+
+	msg := message.Text(chatID, b.String())
+	_, err = tg.api.SendMessageContext(ctx, msg)
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"vybar/tg"
+	"vybar/tg/bot"
+	"vybar/tg/file"
+	"vybar/tg/message"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newRouter routes updates to the handlers of the bot. Routes are tried in
+// order, so commands and buttons go before free text.
+func newRouter(b *TGBot) *bot.Router {
+	r := bot.NewRouter(
+		b.api.Username(),
+		bot.OnError(logError),
+		bot.OnUnhandled(b.pending.keep),
+		bot.Workers(b.workers, b.updatesBacklog),
+	)
+	logger := logrus.StandardLogger()
+	r.Use(
+		// outermost, so updates which panic are forgotten too
+		b.pending.forget,
+		bot.Recover(logger),
+		bot.Logging(logger),
+		// pressed buttons spin until answered and ballots must not be lost
+		bot.Except(
+			func(upd *tg.Update) bool {
+				return upd.CallbackQuery != nil || isBallotVideo(upd)
+			},
+			bot.RateLimit(b.updatesRate, b.updatesBurst, logger),
+		),
+	)
+	coordinator := bot.Only(func(upd *tg.Update) bool {
+		return b.isCoordinator(bot.ChatID(upd))
+	})
+
+	r.Kind(bot.KindMyChatMember, func(ctx context.Context, upd *tg.Update) error {
+		return b.processChatMember(ctx, upd.MyChatMember)
+	})
+	r.Kind(bot.KindEditedMessage, func(ctx context.Context, upd *tg.Update) error {
+		// codes, precincts and videos are taken from new messages only
+		logrus.Debugf("ignore edited message %d in chat %d", upd.EditedMessage.ID, upd.EditedMessage.Chat.ID)
+		return nil
+	})
+
+	r.Callback(verdictPrefix+":", func(ctx context.Context, upd *tg.Update) error {
+		return b.processVerdict(ctx, upd.CallbackQuery)
+	})
+	r.Callback(confirmPrefix+":", func(ctx context.Context, upd *tg.Update) error {
+		return b.processConfirmation(ctx, upd.CallbackQuery)
+	})
+	r.Callback(precinctPrefix+":", func(ctx context.Context, upd *tg.Update) error {
+		return b.processPrecinctCallback(ctx, upd.CallbackQuery)
+	})
+	r.Kind(bot.KindCallbackQuery, func(ctx context.Context, upd *tg.Update) error {
+		q := upd.CallbackQuery
+		if q.Data == nil {
+			return b.api.AnswerCallbackQueryContext(ctx, q.ID, "", false)
+		}
+		return b.api.AnswerCallbackQueryContext(ctx, q.ID, "Неизвестная команда", false)
+	})
+
+	r.Command("start", func(ctx context.Context, upd *tg.Update) error {
+		logrus.Debug("start working with new user")
+		return b.welcomeMessage(ctx, upd.Message.Chat.ID)
+	})
+	r.Command("cancel", func(ctx context.Context, upd *tg.Update) error {
+		return b.processCancel(ctx, upd.Message.Chat.ID)
+	})
+	r.Command("results", coordinator(func(ctx context.Context, upd *tg.Update) error {
+		precinct := ""
+		if fields := strings.Fields(bot.Args(upd)); len(fields) > 0 {
+			precinct = fields[0]
+		}
+		return b.processResults(ctx, upd.Message.Chat.ID, precinct)
+	}))
+	r.Command("escalated", coordinator(func(ctx context.Context, upd *tg.Update) error {
+		return b.processEscalated(ctx, upd.Message.Chat.ID)
+	}))
+	r.Text(txtVote, func(ctx context.Context, upd *tg.Update) error {
+		return b.processVoteRequest(ctx, upd.Message.Chat.ID)
+	})
+	r.Text(txtVolunteer, func(ctx context.Context, upd *tg.Update) error {
+		return b.processModeration(ctx, upd.Message.Chat.ID)
+	})
+	r.AnyText(func(ctx context.Context, upd *tg.Update) error {
+		handled, err := b.processPrecinctText(ctx, upd.Message)
+		if err == nil && !handled {
+			return b.processStrayText(ctx, upd.Message)
+		}
+		return err
+	})
+
+	r.Handle(isBallotVideo, func(ctx context.Context, upd *tg.Update) error {
+		video, _ := videoOf(upd.Message)
+		return b.processVideoMessage(ctx, upd.Message, video)
+	})
+	r.Media(bot.MediaDocument, func(ctx context.Context, upd *tg.Update) error {
+		respMsg := message.Text(
+			upd.Message.Chat.ID,
+			"Этот файл не похож на видео. Пришли, пожалуйста, видео бюллетеня",
+			message.InReplyTo(upd.Message.ID),
+		)
+		_, err := b.api.SendMessageContext(ctx, respMsg)
+		return err
+	})
+	r.Media(bot.MediaPhoto, func(ctx context.Context, upd *tg.Update) error {
+		logrus.Debugf("got photo in %d sizes from %d", len(upd.Message.Photo), upd.Message.Chat.ID)
+		var maxSizeFile *file.PhotoSize
+		maxSize := 0
+		for _, ps := range upd.Message.Photo {
+			s := ps.Width * ps.Height
+			if s > maxSize {
+				maxSize = s
+				maxSizeFile = ps
+			}
+		}
+		if maxSizeFile == nil {
+			return nil
+		}
+		_, err := b.storeFile(ctx, maxSizeFile.ID, "jpg")
+		return err
+	})
+	return r
+}
+
+func isBallotVideo(upd *tg.Update) bool {
+	if upd.Message == nil {
+		return false
+	}
+	_, ok := videoOf(upd.Message)
+	return ok
+}
@@ -3,13 +3,21 @@ package destenation
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
@@ -18,8 +26,64 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 )
 
+var ErrNotFound = errors.New("destenation: file not found")
+
+// Destenation keeps media files. Files are addressed by the path Store
+// returns.
 type Destenation interface {
 	Store(ctx context.Context, f io.Reader, ext string) (string, error)
+	// Open fails with ErrNotFound if there is no such file.
+	Open(ctx context.Context, p string) (io.ReadCloser, error)
+	Stat(ctx context.Context, p string) (*Info, error)
+	// Delete does not fail if there is no such file.
+	Delete(ctx context.Context, p string) error
+	// List returns up to limit files in order of their paths, starting after
+	// the path, or from the first file if after is empty. Zero limit means
+	// defaultListLimit. List may leave ContentType and Checksum empty, Stat
+	// fills them.
+	List(ctx context.Context, after string, limit int) ([]*Info, error)
+}
+
+const defaultListLimit = 1000
+
+type Info struct {
+	Path        string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	// Checksum is the hex MD5 of the content. S3 returns a checksum of the
+	// parts instead for files uploaded in parts, as Store does.
+	Checksum string
+}
+
+// contentTypes covers videos, which mime does not know without system
+// tables.
+var contentTypes = map[string]string{
+	"mp4":  "video/mp4",
+	"mov":  "video/quicktime",
+	"webm": "video/webm",
+	"mkv":  "video/x-matroska",
+	"3gp":  "video/3gpp",
+	"avi":  "video/x-msvideo",
+	"jpg":  "image/jpeg",
+}
+
+func contentType(p string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(p), "."))
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension("." + ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func listLimit(limit int) int {
+	if limit <= 0 {
+		return defaultListLimit
+	}
+	return limit
 }
 
 type FSDestenation struct {
@@ -46,15 +110,121 @@ func (fs *FSDestenation) Store(_ context.Context, f io.Reader, ext string) (stri
 	defer file.Close()
 
 	if _, err := io.Copy(file, f); err != nil {
+		// do not leave a truncated file behind
+		file.Close()
+		os.Remove(p)
 		return "", err
 	}
 
 	return p, file.Close()
 }
 
+// resolve accepts paths returned by Store and bare file names, but nothing
+// outside of the base path.
+func (fs *FSDestenation) resolve(p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(fs.basePath, p)
+	}
+	p = filepath.Clean(p)
+	if filepath.Dir(p) != filepath.Clean(fs.basePath) {
+		return "", fmt.Errorf("destenation: %s is outside of %s", p, fs.basePath)
+	}
+	return p, nil
+}
+
+func (fs *FSDestenation) Open(_ context.Context, p string) (io.ReadCloser, error) {
+	p, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Stat reads the whole file to get its checksum.
+func (fs *FSDestenation) Stat(ctx context.Context, p string) (*Info, error) {
+	p, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) || err == nil && fi.IsDir() {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := fs.Open(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+
+	info := fs.info(p, fi)
+	info.ContentType = contentType(p)
+	info.Checksum = hex.EncodeToString(h.Sum(nil))
+	return info, nil
+}
+
+func (fs *FSDestenation) info(p string, fi os.FileInfo) *Info {
+	return &Info{
+		Path:    p,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+}
+
+func (fs *FSDestenation) Delete(_ context.Context, p string) error {
+	p, err := fs.resolve(p)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (fs *FSDestenation) List(_ context.Context, after string, limit int) ([]*Info, error) {
+	limit = listLimit(limit)
+	// sorted by name, which is the order of paths in one directory
+	entries, err := ioutil.ReadDir(fs.basePath)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Info, 0)
+	for _, fi := range entries {
+		if len(res) == limit {
+			break
+		}
+		if fi.IsDir() || after != "" && fi.Name() <= filepath.Base(after) {
+			continue
+		}
+		p := filepath.Join(fs.basePath, fi.Name())
+		info := fs.info(p, fi)
+		info.ContentType = contentType(p)
+		res = append(res, info)
+	}
+	return res, nil
+}
+
+const abortTimeout = 30 * time.Second
+
 type S3Destenation struct {
 	cli      *s3.Client
 	bucket   string
+	prefix   string
 	endpoint aws.EndpointResolver
 }
 
@@ -66,6 +236,14 @@ func WithCustomEndpoint(uri string) S3DestenationOption {
 	}
 }
 
+// WithKeyPrefix stores files under the prefix, e.g. "ballots/", so they are
+// told apart from other objects in a shared bucket.
+func WithKeyPrefix(prefix string) S3DestenationOption {
+	return func(dst *S3Destenation) {
+		dst.prefix = prefix
+	}
+}
+
 func NewS3Destenation(bucket, key, secret, region string, options ...S3DestenationOption) (*S3Destenation, error) {
 	cfg, err := external.LoadDefaultAWSConfig()
 	if err != nil {
@@ -90,10 +268,8 @@ func NewS3Destenation(bucket, key, secret, region string, options ...S3Destenati
 	if err != nil {
 		return nil, err
 	}
-	return &S3Destenation{
-		cli:    cli,
-		bucket: bucket,
-	}, nil
+	s3dst.cli = cli
+	return &s3dst, nil
 }
 
 func (s *S3Destenation) abortMultipartUpload(ctx context.Context, key string, uploadID *string) error {
@@ -105,17 +281,67 @@ func (s *S3Destenation) abortMultipartUpload(ctx context.Context, key string, up
 	return err
 }
 
-func (s *S3Destenation) Store(ctx context.Context, f io.Reader, ext string) (string, error) {
+// AbortIncompleteUploads aborts multipart uploads under the key prefix
+// which are older than age, e.g. left by a run which was killed in the
+// middle of an upload. Storage keeps their parts and charges for them until
+// they are aborted. Age must be well above the time an upload takes, as
+// another instance may still be finishing its uploads.
+func (s *S3Destenation) AbortIncompleteUploads(ctx context.Context, age time.Duration) (int, error) {
+	if s.prefix == "" {
+		return 0, errors.New("destenation: refuse to abort uploads in the whole bucket, set a key prefix")
+	}
+	before := time.Now().Add(-age)
+	aborted := 0
+	input := s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	}
+	for {
+		resp, err := s.cli.ListMultipartUploadsRequest(&input).Send(ctx)
+		if err != nil {
+			return aborted, err
+		}
+		for _, u := range resp.Uploads {
+			if u.Key == nil || u.Initiated == nil || !u.Initiated.Before(before) {
+				continue
+			}
+			if err := s.abortMultipartUpload(ctx, *u.Key, u.UploadId); err != nil {
+				return aborted, err
+			}
+			aborted++
+		}
+		if resp.IsTruncated == nil || !*resp.IsTruncated {
+			return aborted, nil
+		}
+		input.KeyMarker = resp.NextKeyMarker
+		input.UploadIdMarker = resp.NextUploadIdMarker
+	}
+}
+
+func (s *S3Destenation) Store(ctx context.Context, f io.Reader, ext string) (_ string, err error) {
 	fname := uuid.New()
-	path := fmt.Sprintf("%s.%s", fname, ext)
+	path := fmt.Sprintf("%s%s.%s", s.prefix, fname, ext)
 	resp, err := s.cli.CreateMultipartUploadRequest(&s3.CreateMultipartUploadInput{
-		Bucket: aws.String(s.bucket),
-		ACL:    s3.ObjectCannedACLPrivate,
-		Key:    aws.String(path),
+		Bucket:      aws.String(s.bucket),
+		ACL:         s3.ObjectCannedACLPrivate,
+		Key:         aws.String(path),
+		ContentType: aws.String(contentType(path)),
 	}).Send(ctx)
 	if err != nil {
 		return "", err
 	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// ctx is likely done already, e.g. on shutdown, but the parts must
+		// be dropped anyway
+		abortCtx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+		defer cancel()
+		if abortErr := s.abortMultipartUpload(abortCtx, path, resp.UploadId); abortErr != nil {
+			logrus.Errorf("failed to abort upload of %s: %s", path, abortErr)
+		}
+	}()
 
 	size := 5 * 1024 * 1024
 	buffer := make([]byte, size)
@@ -174,6 +400,77 @@ func (s *S3Destenation) Store(ctx context.Context, f io.Reader, ext string) (str
 	return path, nil
 }
 
+func (s *S3Destenation) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	resp, err := s.cli.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(p),
+	}).Send(ctx)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Destenation) Stat(ctx context.Context, p string) (*Info, error) {
+	resp, err := s.cli.HeadObjectRequest(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(p),
+	}).Send(ctx)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	info := Info{
+		Path:        p,
+		Size:        aws.Int64Value(resp.ContentLength),
+		ModTime:     aws.TimeValue(resp.LastModified),
+		ContentType: aws.StringValue(resp.ContentType),
+		Checksum:    strings.Trim(aws.StringValue(resp.ETag), `"`),
+	}
+	return &info, nil
+}
+
+func (s *S3Destenation) Delete(ctx context.Context, p string) error {
+	_, err := s.cli.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(p),
+	}).Send(ctx)
+	return err
+}
+
+func (s *S3Destenation) List(ctx context.Context, after string, limit int) ([]*Info, error) {
+	input := s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(s.prefix),
+		MaxKeys: aws.Int64(int64(listLimit(limit))),
+	}
+	if after != "" {
+		input.StartAfter = aws.String(after)
+	}
+	resp, err := s.cli.ListObjectsV2Request(&input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Info, 0, len(resp.Contents))
+	for _, obj := range resp.Contents {
+		res = append(res, &Info{
+			Path:     aws.StringValue(obj.Key),
+			Size:     aws.Int64Value(obj.Size),
+			ModTime:  aws.TimeValue(obj.LastModified),
+			Checksum: strings.Trim(aws.StringValue(obj.ETag), `"`),
+		})
+	}
+	return res, nil
+}
+
+// s3Error turns the missing object into ErrNotFound.
+func s3Error(err error) error {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
 func (s *S3Destenation) PublicURL(ctx context.Context, p string) (string, error) {
 	req := s.cli.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
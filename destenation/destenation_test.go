@@ -0,0 +1,113 @@
+package destenation
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testDestenation checks the contract of Destenation which the bot relies
+// on, with the memory destenation standing in for remote storages.
+func testDestenation(t *testing.T, d Destenation) {
+	ctx := context.Background()
+	content := []string{"first video", "second video", "third video"}
+	paths := make([]string, 0, len(content))
+	for _, c := range content {
+		p, err := d.Store(ctx, strings.NewReader(c), "mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(p, ".mp4") {
+			t.Errorf("path %q has no extension", p)
+		}
+		paths = append(paths, p)
+	}
+
+	t.Run("open", func(t *testing.T) {
+		f, err := d.Open(ctx, paths[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content[0] {
+			t.Errorf("read %q, want %q", data, content[0])
+		}
+	})
+
+	t.Run("stat", func(t *testing.T) {
+		info, err := d.Stat(ctx, paths[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := md5.Sum([]byte(content[1]))
+		if info.Path != paths[1] || info.Size != int64(len(content[1])) ||
+			info.ContentType != "video/mp4" || info.Checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("got %+v", info)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		all, err := d.List(ctx, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(paths) {
+			t.Fatalf("listed %d files, want %d", len(all), len(paths))
+		}
+		for i := 1; i < len(all); i++ {
+			if all[i-1].Path >= all[i].Path {
+				t.Errorf("%q is listed before %q", all[i-1].Path, all[i].Path)
+			}
+		}
+
+		page, err := d.List(ctx, all[0].Path, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 1 || page[0].Path != all[1].Path {
+			t.Errorf("listed %v after %q, want %q", page, all[0].Path, all[1].Path)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := d.Delete(ctx, paths[2]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Open(ctx, paths[2]); !errors.Is(err, ErrNotFound) {
+			t.Errorf("open of a deleted file: %v", err)
+		}
+		if _, err := d.Stat(ctx, paths[2]); !errors.Is(err, ErrNotFound) {
+			t.Errorf("stat of a deleted file: %v", err)
+		}
+		if err := d.Delete(ctx, paths[2]); err != nil {
+			t.Errorf("delete of a deleted file: %v", err)
+		}
+	})
+}
+
+func TestMemoryDestenation(t *testing.T) {
+	testDestenation(t, NewMemoryDestenation())
+}
+
+func TestFSDestenation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vybar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	d, err := NewFSDestenation(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDestenation(t, d)
+}
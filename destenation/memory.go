@@ -0,0 +1,120 @@
+package destenation
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// MemoryDestenation keeps files in memory, for tests.
+type MemoryDestenation struct {
+	mu    sync.RWMutex
+	files map[string]*memoryFile
+}
+
+func NewMemoryDestenation() *MemoryDestenation {
+	return &MemoryDestenation{
+		files: make(map[string]*memoryFile),
+	}
+}
+
+func (m *MemoryDestenation) Store(ctx context.Context, f io.Reader, ext string) (string, error) {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	p := fmt.Sprintf("%s.%s", uuid.New(), ext)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[p] = &memoryFile{
+		data:    data,
+		modTime: time.Now(),
+	}
+	return p, nil
+}
+
+func (m *MemoryDestenation) file(p string) (*memoryFile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.files[p]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return f, nil
+}
+
+func (m *MemoryDestenation) Open(_ context.Context, p string) (io.ReadCloser, error) {
+	f, err := m.file(p)
+	if err != nil {
+		return nil, err
+	}
+	// stored data is never modified, so it can be read without a copy
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (m *MemoryDestenation) Stat(_ context.Context, p string) (*Info, error) {
+	f, err := m.file(p)
+	if err != nil {
+		return nil, err
+	}
+	return m.info(p, f), nil
+}
+
+func (m *MemoryDestenation) info(p string, f *memoryFile) *Info {
+	sum := md5.Sum(f.data)
+	return &Info{
+		Path:        p,
+		Size:        int64(len(f.data)),
+		ModTime:     f.modTime,
+		ContentType: contentType(p),
+		Checksum:    hex.EncodeToString(sum[:]),
+	}
+}
+
+func (m *MemoryDestenation) Delete(_ context.Context, p string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, p)
+	return nil
+}
+
+func (m *MemoryDestenation) List(_ context.Context, after string, limit int) ([]*Info, error) {
+	limit = listLimit(limit)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	paths := make([]string, 0, len(m.files))
+	for p := range m.files {
+		if p > after {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	if len(paths) > limit {
+		paths = paths[:limit]
+	}
+
+	res := make([]*Info, 0, len(paths))
+	for _, p := range paths {
+		res = append(res, m.info(p, m.files[p]))
+	}
+	return res, nil
+}
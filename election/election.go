@@ -0,0 +1,274 @@
+package election
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type Kind string
+
+const (
+	KindCandidate  Kind = "candidate"
+	KindAgainstAll Kind = "against_all"
+	KindSpoiled    Kind = "spoiled"
+	KindInvalid    Kind = "invalid"
+)
+
+// maxIDLength keeps identifiers short enough to fit into callback data of
+// inline buttons.
+const maxIDLength = 24
+
+type Option struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Kind  Kind   `json:"kind,omitempty"`
+}
+
+type Ballot struct {
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Options []Option `json:"options"`
+}
+
+type Outcome struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Kind  Kind   `json:"kind"`
+}
+
+type Precinct struct {
+	ID         string `json:"id"`
+	Region     string `json:"region"`
+	Commission string `json:"commission"`
+	Address    string `json:"address,omitempty"`
+}
+
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type Election struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Ballots   []Ballot   `json:"ballots"`
+	Outcomes  []Outcome  `json:"outcomes"`
+	Precincts []Precinct `json:"precincts"`
+	Voting    Window     `json:"voting"`
+}
+
+// Choice is anything a moderator can answer about a ballot video: an option
+// of one of the ballots or a special outcome.
+type Choice struct {
+	ID     string
+	Title  string
+	Kind   Kind
+	Ballot string
+}
+
+func Load(path string) (*Election, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var e Election
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&e); err != nil {
+		return nil, fmt.Errorf("election: failed to parse %s: %w", path, err)
+	}
+	if err := e.init(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (e *Election) init() error {
+	if e.ID == "" {
+		return fmt.Errorf("election: id is required")
+	}
+	// a submission records a single result, so options of several ballots
+	// can not be told apart
+	if len(e.Ballots) != 1 {
+		return fmt.Errorf("election: exactly one ballot is required, got %d", len(e.Ballots))
+	}
+	if !e.Voting.Start.IsZero() && !e.Voting.End.IsZero() && !e.Voting.End.After(e.Voting.Start) {
+		return fmt.Errorf("election: voting ends before it starts")
+	}
+
+	if !e.hasOutcome(KindSpoiled) {
+		e.Outcomes = append(e.Outcomes, Outcome{ID: "spoiled", Title: "Бюллетень испорчен", Kind: KindSpoiled})
+	}
+	if !e.hasOutcome(KindInvalid) {
+		e.Outcomes = append(e.Outcomes, Outcome{ID: "invalid", Title: "Видео не соответствует требованиям", Kind: KindInvalid})
+	}
+
+	ids := make(map[string]bool)
+	checkID := func(id string) error {
+		if err := validID(id); err != nil {
+			return err
+		}
+		if ids[id] {
+			return fmt.Errorf("election: duplicate id %q", id)
+		}
+		ids[id] = true
+		return nil
+	}
+
+	for i := range e.Ballots {
+		b := &e.Ballots[i]
+		if len(b.Options) == 0 {
+			return fmt.Errorf("election: ballot %q has no options", b.ID)
+		}
+		for j := range b.Options {
+			opt := &b.Options[j]
+			if opt.Kind == "" {
+				opt.Kind = KindCandidate
+			}
+			if opt.Kind != KindCandidate && opt.Kind != KindAgainstAll {
+				return fmt.Errorf("election: option %q has unexpected kind %q", opt.ID, opt.Kind)
+			}
+			if err := checkID(opt.ID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, o := range e.Outcomes {
+		if o.Kind != KindSpoiled && o.Kind != KindInvalid {
+			return fmt.Errorf("election: outcome %q has unexpected kind %q", o.ID, o.Kind)
+		}
+		if err := checkID(o.ID); err != nil {
+			return err
+		}
+	}
+
+	precincts := make(map[string]bool)
+	for _, p := range e.Precincts {
+		if err := validID(p.ID); err != nil {
+			return err
+		}
+		// they are the text of buttons, which telegram requires
+		if strings.TrimSpace(p.Region) == "" || strings.TrimSpace(p.Commission) == "" {
+			return fmt.Errorf("election: precinct %q must have a region and a commission", p.ID)
+		}
+		if precincts[p.ID] {
+			return fmt.Errorf("election: duplicate precinct %q", p.ID)
+		}
+		precincts[p.ID] = true
+	}
+	return nil
+}
+
+func validID(id string) error {
+	if id == "" {
+		return fmt.Errorf("election: empty id")
+	}
+	if len(id) > maxIDLength || strings.ContainsAny(id, ": ") {
+		return fmt.Errorf("election: id %q must be up to %d characters without spaces and colons", id, maxIDLength)
+	}
+	return nil
+}
+
+func (e *Election) hasOutcome(kind Kind) bool {
+	for _, o := range e.Outcomes {
+		if o.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Choices lists options of the ballot followed by special outcomes.
+func (e *Election) Choices() []Choice {
+	res := make([]Choice, 0)
+	for _, b := range e.Ballots {
+		for _, opt := range b.Options {
+			res = append(res, Choice{
+				ID:     opt.ID,
+				Title:  opt.Title,
+				Kind:   opt.Kind,
+				Ballot: b.ID,
+			})
+		}
+	}
+	for _, o := range e.Outcomes {
+		res = append(res, Choice{
+			ID:    o.ID,
+			Title: o.Title,
+			Kind:  o.Kind,
+		})
+	}
+	return res
+}
+
+func (e *Election) Choice(id string) (Choice, bool) {
+	for _, c := range e.Choices() {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return Choice{}, false
+}
+
+func (e *Election) Precinct(id string) (*Precinct, bool) {
+	for i := range e.Precincts {
+		if e.Precincts[i].ID == id {
+			return &e.Precincts[i], true
+		}
+	}
+	return nil, false
+}
+
+// IsOpen reports whether t falls into the voting window. Unset bounds are
+// not checked.
+func (e *Election) IsOpen(t time.Time) bool {
+	if !e.Voting.Start.IsZero() && t.Before(e.Voting.Start) {
+		return false
+	}
+	if !e.Voting.End.IsZero() && !t.Before(e.Voting.End) {
+		return false
+	}
+	return true
+}
+
+// Regions lists regions of precincts in the order of the definition.
+func (e *Election) Regions() []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, p := range e.Precincts {
+		if !seen[p.Region] {
+			seen[p.Region] = true
+			res = append(res, p.Region)
+		}
+	}
+	return res
+}
+
+// Commissions lists territorial commissions of the region in the order of
+// the definition.
+func (e *Election) Commissions(region string) []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, p := range e.Precincts {
+		if p.Region == region && !seen[p.Commission] {
+			seen[p.Commission] = true
+			res = append(res, p.Commission)
+		}
+	}
+	return res
+}
+
+func (e *Election) PrecinctsOf(region, commission string) []Precinct {
+	res := make([]Precinct, 0)
+	for _, p := range e.Precincts {
+		if p.Region == region && p.Commission == commission {
+			res = append(res, p)
+		}
+	}
+	return res
+}
@@ -0,0 +1,267 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+	"vybar/election"
+	"vybar/store"
+	"vybar/tally"
+)
+
+type Filter struct {
+	Precinct string
+	From     time.Time
+	To       time.Time
+	Status   store.SubmissionStatus
+}
+
+func (f *Filter) Match(sub *store.Submission) bool {
+	if f.Precinct != "" && sub.Precinct != f.Precinct {
+		return false
+	}
+	if !f.From.IsZero() && sub.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !sub.CreatedAt.Before(f.To) {
+		return false
+	}
+	if f.Status != "" && sub.Status != f.Status {
+		return false
+	}
+	return true
+}
+
+// Submissions keeps matching submissions ordered by ID.
+func (f *Filter) Submissions(subs []*store.Submission) []*store.Submission {
+	res := make([]*store.Submission, 0, len(subs))
+	for _, sub := range subs {
+		if f.Match(sub) {
+			res = append(res, sub)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// Verdicts keeps verdicts of the submissions ordered by submission and reviewer.
+func Verdicts(subs []*store.Submission, verdicts []*store.Verdict) []*store.Verdict {
+	ids := make(map[int64]bool, len(subs))
+	for _, sub := range subs {
+		ids[sub.ID] = true
+	}
+	res := make([]*store.Verdict, 0, len(verdicts))
+	for _, v := range verdicts {
+		if ids[v.SubmissionID] {
+			res = append(res, v)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].SubmissionID != res[j].SubmissionID {
+			return res[i].SubmissionID < res[j].SubmissionID
+		}
+		return res[i].ReviewerID < res[j].ReviewerID
+	})
+	return res
+}
+
+// Tally counts the submissions. The time of the result is the latest change
+// among them, so repeated exports of the same data are identical.
+func Tally(e *election.Election, subs []*store.Submission) *tally.Result {
+	var at time.Time
+	for _, sub := range subs {
+		if sub.CreatedAt.After(at) {
+			at = sub.CreatedAt
+		}
+		if sub.DecidedAt.After(at) {
+			at = sub.DecidedAt
+		}
+	}
+	return tally.Count(e, subs, at.UTC())
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func TallyCSV(w io.Writer, e *election.Election, res *tally.Result) error {
+	choices := make([]election.Choice, 0)
+	for _, c := range e.Choices() {
+		if c.Kind != election.KindSpoiled && c.Kind != election.KindInvalid {
+			choices = append(choices, c)
+		}
+	}
+
+	header := []string{"precinct"}
+	for _, c := range choices {
+		header = append(header, c.ID)
+	}
+	header = append(header, "accepted", "spoiled", "rejected", "unknown", "pending", "escalated", "duplicates", "total")
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	row := func(name string, c *tally.Counts) error {
+		rec := []string{name}
+		for _, choice := range choices {
+			rec = append(rec, strconv.Itoa(c.Votes[choice.ID]))
+		}
+		for _, v := range []int{c.Accepted(), c.Spoiled, c.Rejected, c.Unknown, c.Pending, c.Escalated, c.Duplicates, c.Total} {
+			rec = append(rec, strconv.Itoa(v))
+		}
+		return cw.Write(rec)
+	}
+	for _, id := range res.PrecinctIDs() {
+		if err := row(id, res.Precincts[id]); err != nil {
+			return err
+		}
+	}
+	if err := row("total", res.Overall); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func TallyJSON(w io.Writer, res *tally.Result) error {
+	return writeJSON(w, res)
+}
+
+type submissionRecord struct {
+	ID           int64                  `json:"id"`
+	Code         string                 `json:"code"`
+	Precinct     string                 `json:"precinct"`
+	Path         string                 `json:"path"`
+	FileID       string                 `json:"file_id"`
+	FileUniqueID string                 `json:"file_unique_id"`
+	Size         int                    `json:"size"`
+	Duration     int                    `json:"duration"`
+	Status       store.SubmissionStatus `json:"status"`
+	Result       string                 `json:"result"`
+	Reviews      int                    `json:"reviews"`
+	CreatedAt    string                 `json:"created_at"`
+	DecidedAt    string                 `json:"decided_at"`
+}
+
+func newSubmissionRecord(sub *store.Submission) submissionRecord {
+	return submissionRecord{
+		ID:           sub.ID,
+		Code:         sub.Code,
+		Precinct:     sub.Precinct,
+		Path:         sub.Path,
+		FileID:       sub.FileID,
+		FileUniqueID: sub.FileUniqueID,
+		Size:         sub.Size,
+		Duration:     sub.Duration,
+		Status:       sub.Status,
+		Result:       sub.Result,
+		Reviews:      sub.Reviews,
+		CreatedAt:    formatTime(sub.CreatedAt),
+		DecidedAt:    formatTime(sub.DecidedAt),
+	}
+}
+
+func SubmissionsCSV(w io.Writer, subs []*store.Submission) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{
+		"id", "code", "precinct", "path", "file_id", "file_unique_id", "size", "duration",
+		"status", "result", "reviews", "created_at", "decided_at",
+	})
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		r := newSubmissionRecord(sub)
+		err := cw.Write([]string{
+			strconv.FormatInt(r.ID, 10),
+			r.Code,
+			r.Precinct,
+			r.Path,
+			r.FileID,
+			r.FileUniqueID,
+			strconv.Itoa(r.Size),
+			strconv.Itoa(r.Duration),
+			string(r.Status),
+			r.Result,
+			strconv.Itoa(r.Reviews),
+			r.CreatedAt,
+			r.DecidedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func SubmissionsJSON(w io.Writer, subs []*store.Submission) error {
+	res := make([]submissionRecord, 0, len(subs))
+	for _, sub := range subs {
+		res = append(res, newSubmissionRecord(sub))
+	}
+	return writeJSON(w, res)
+}
+
+type verdictRecord struct {
+	SubmissionID int64  `json:"submission_id"`
+	ReviewerID   int64  `json:"reviewer_id"`
+	Option       string `json:"option"`
+	Final        bool   `json:"final"`
+	CreatedAt    string `json:"created_at"`
+}
+
+func newVerdictRecord(v *store.Verdict) verdictRecord {
+	return verdictRecord{
+		SubmissionID: v.SubmissionID,
+		ReviewerID:   v.ReviewerID,
+		Option:       v.Option,
+		Final:        v.Final,
+		CreatedAt:    formatTime(v.CreatedAt),
+	}
+}
+
+func VerdictsCSV(w io.Writer, verdicts []*store.Verdict) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"submission_id", "reviewer_id", "option", "final", "created_at"}); err != nil {
+		return err
+	}
+	for _, v := range verdicts {
+		r := newVerdictRecord(v)
+		err := cw.Write([]string{
+			strconv.FormatInt(r.SubmissionID, 10),
+			strconv.FormatInt(r.ReviewerID, 10),
+			r.Option,
+			strconv.FormatBool(r.Final),
+			r.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func VerdictsJSON(w io.Writer, verdicts []*store.Verdict) error {
+	res := make([]verdictRecord, 0, len(verdicts))
+	for _, v := range verdicts {
+		res = append(res, newVerdictRecord(v))
+	}
+	return writeJSON(w, res)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
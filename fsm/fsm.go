@@ -0,0 +1,194 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"vybar/store"
+)
+
+// Initial is the state of chats which have no state stored.
+const Initial = ""
+
+var ErrTransition = errors.New("fsm: transition is not allowed")
+
+type Store interface {
+	GetState(ctx context.Context, chatID int64) (*store.State, error)
+	SaveState(ctx context.Context, s *store.State) error
+	DeleteState(ctx context.Context, chatID int64) error
+}
+
+type state struct {
+	timeout   time.Duration
+	onTimeout string
+	next      map[string]bool
+}
+
+// Machine keeps the step of the conversation with every chat. States and
+// transitions between them are defined up front, a chat can move only along
+// them. A state may expire, the chat then falls back to another state.
+// Machine is safe for concurrent use, but updates of one chat must not be
+// handled concurrently, or transitions of the chat may be lost.
+type Machine struct {
+	store Store
+
+	mu     sync.RWMutex
+	states map[string]*state
+}
+
+func New(st Store) *Machine {
+	return &Machine{
+		store: st,
+		states: map[string]*state{
+			Initial: {next: make(map[string]bool)},
+		},
+	}
+}
+
+// Define adds the state with states reachable from it. A chat stays in the
+// state for timeout at most, zero means forever, and then moves to
+// onTimeout.
+func (m *Machine) Define(name string, timeout time.Duration, onTimeout string, next ...string) {
+	s := state{
+		timeout:   timeout,
+		onTimeout: onTimeout,
+		next:      make(map[string]bool, len(next)),
+	}
+	for _, n := range next {
+		s.next[n] = true
+	}
+	m.mu.Lock()
+	m.states[name] = &s
+	m.mu.Unlock()
+}
+
+// Allow adds transitions from the state, e.g. from Initial.
+func (m *Machine) Allow(from string, next ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.states[from]
+	if !ok {
+		s = &state{next: make(map[string]bool)}
+		m.states[from] = s
+	}
+	for _, n := range next {
+		s.next[n] = true
+	}
+}
+
+// Current returns the state of the chat. An expired state is replaced with
+// its fallback, expired is then the name of the state the chat was in, or
+// empty otherwise.
+func (m *Machine) Current(ctx context.Context, chatID int64) (*store.State, string, error) {
+	st, err := m.store.GetState(ctx, chatID)
+	if errors.Is(err, store.ErrNotFound) {
+		return m.initial(chatID), "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	from := st.Name
+	timedOut := false
+	for !st.ExpiresAt.IsZero() && !now.Before(st.ExpiresAt) {
+		timedOut = true
+		def, ok := m.state(st.Name)
+		if !ok {
+			st = m.initial(chatID)
+			break
+		}
+		st = m.enter(chatID, def.onTimeout, nil, st.ExpiresAt)
+	}
+	if !timedOut {
+		return st, "", nil
+	}
+	if err := m.save(ctx, st); err != nil {
+		return nil, "", err
+	}
+	return st, from, nil
+}
+
+// Transition moves the chat to the state if it is reachable from the current
+// one. Data is kept with the state until the next transition.
+func (m *Machine) Transition(ctx context.Context, chatID int64, to string, data interface{}) (*store.State, error) {
+	cur, _, err := m.Current(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !m.allowed(cur.Name, to) {
+		return nil, fmt.Errorf("%w: from %q to %q", ErrTransition, cur.Name, to)
+	}
+	if _, ok := m.state(to); !ok {
+		return nil, fmt.Errorf("fsm: unknown state %q", to)
+	}
+
+	var raw json.RawMessage
+	if data != nil {
+		if raw, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+	}
+	st := m.enter(chatID, to, raw, time.Now())
+	if err := m.save(ctx, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Reset moves the chat to the initial state from any state.
+func (m *Machine) Reset(ctx context.Context, chatID int64) error {
+	return m.store.DeleteState(ctx, chatID)
+}
+
+func (m *Machine) state(name string) (*state, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.states[name]
+	return s, ok
+}
+
+func (m *Machine) allowed(from, to string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.states[from]
+	return ok && s.next[to]
+}
+
+func (m *Machine) initial(chatID int64) *store.State {
+	return &store.State{
+		ChatID: chatID,
+		Name:   Initial,
+	}
+}
+
+func (m *Machine) enter(chatID int64, name string, data json.RawMessage, at time.Time) *store.State {
+	st := store.State{
+		ChatID:    chatID,
+		Name:      name,
+		Data:      data,
+		EnteredAt: at,
+	}
+	if def, ok := m.state(name); ok && def.timeout > 0 {
+		st.ExpiresAt = at.Add(def.timeout)
+	}
+	return &st
+}
+
+func (m *Machine) save(ctx context.Context, st *store.State) error {
+	if st.Name == Initial {
+		return m.store.DeleteState(ctx, st.ChatID)
+	}
+	return m.store.SaveState(ctx, st)
+}
+
+// Decode reads data kept with the state.
+func Decode(st *store.State, v interface{}) error {
+	if len(st.Data) == 0 {
+		return fmt.Errorf("fsm: state %q has no data", st.Name)
+	}
+	return json.Unmarshal(st.Data, v)
+}
@@ -0,0 +1,168 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"vybar/store"
+)
+
+type memStore struct {
+	states map[int64]store.State
+}
+
+func (s *memStore) GetState(ctx context.Context, chatID int64) (*store.State, error) {
+	st, ok := s.states[chatID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &st, nil
+}
+
+func (s *memStore) SaveState(ctx context.Context, st *store.State) error {
+	s.states[st.ChatID] = *st
+	return nil
+}
+
+func (s *memStore) DeleteState(ctx context.Context, chatID int64) error {
+	delete(s.states, chatID)
+	return nil
+}
+
+// newTestMachine defines a machine like the journey of a voter: a code is
+// waited for, a video is waited for and then confirmed.
+func newTestMachine() (*Machine, *memStore) {
+	st := memStore{states: make(map[int64]store.State)}
+	m := New(&st)
+	m.Define("code", 10*time.Minute, Initial, "video")
+	m.Define("video", 30*time.Minute, Initial, "confirm")
+	m.Define("confirm", 5*time.Minute, "video", "video")
+	m.Define("forever", 0, Initial)
+	m.Allow(Initial, "code")
+	return m, &st
+}
+
+func TestCurrent(t *testing.T) {
+	tests := []struct {
+		name        string
+		stored      string
+		ago         time.Duration
+		want        string
+		wantExpired string
+	}{
+		{name: "no state", want: Initial},
+		{name: "fresh state", stored: "confirm", ago: time.Minute, want: "confirm"},
+		{name: "expired state", stored: "code", ago: 11 * time.Minute, want: Initial, wantExpired: "code"},
+		{name: "fallback", stored: "confirm", ago: 6 * time.Minute, want: "video", wantExpired: "confirm"},
+		{name: "expired fallback", stored: "confirm", ago: 40 * time.Minute, want: Initial, wantExpired: "confirm"},
+		{name: "state without timeout", stored: "forever", ago: 24 * time.Hour, want: "forever"},
+		{name: "unknown state", stored: "gone", ago: time.Hour, want: Initial, wantExpired: "gone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, st := newTestMachine()
+			ctx := context.Background()
+			if tt.stored != "" {
+				entered := time.Now().Add(-tt.ago)
+				stored := store.State{
+					ChatID:    1,
+					Name:      tt.stored,
+					EnteredAt: entered,
+					// unknown states keep the expiry they were saved with
+					ExpiresAt: entered.Add(time.Minute),
+				}
+				if _, ok := m.state(tt.stored); ok {
+					stored = *m.enter(1, tt.stored, nil, entered)
+				}
+				st.states[1] = stored
+			}
+
+			got, expired, err := m.Current(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.want || expired != tt.wantExpired {
+				t.Errorf("got %q expired %q, want %q expired %q", got.Name, expired, tt.want, tt.wantExpired)
+			}
+
+			saved, ok := st.states[1]
+			switch {
+			case tt.want == Initial && ok:
+				t.Errorf("initial state is stored as %q", saved.Name)
+			case tt.want != Initial && saved.Name != tt.want:
+				t.Errorf("stored %q, want %q", saved.Name, tt.want)
+			}
+			if !got.ExpiresAt.IsZero() && !got.ExpiresAt.After(time.Now()) {
+				t.Errorf("current state expired at %s", got.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestFallbackExpiresFromTimeout(t *testing.T) {
+	m, st := newTestMachine()
+	entered := time.Now().Add(-6 * time.Minute)
+	st.states[1] = *m.enter(1, "confirm", nil, entered)
+
+	got, _, err := m.Current(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the chat is in the fallback since the confirmation expired, not since
+	// it was noticed
+	if want := entered.Add(5 * time.Minute); !got.EnteredAt.Equal(want) {
+		t.Errorf("entered %s, want %s", got.EnteredAt, want)
+	}
+}
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		wantErr error
+	}{
+		{name: "allowed from initial", from: Initial, to: "code"},
+		{name: "allowed", from: "code", to: "video"},
+		{name: "back to fallback", from: "confirm", to: "video"},
+		{name: "not allowed", from: Initial, to: "video", wantErr: ErrTransition},
+		{name: "skipping a state", from: "code", to: "confirm", wantErr: ErrTransition},
+		{name: "to itself", from: "video", to: "video", wantErr: ErrTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, st := newTestMachine()
+			ctx := context.Background()
+			if tt.from != Initial {
+				st.states[1] = *m.enter(1, tt.from, nil, time.Now())
+			}
+
+			got, err := m.Transition(ctx, 1, tt.to, map[string]string{"code": "ABC"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if st.states[1].Name != tt.from {
+					t.Errorf("state changed to %q", st.states[1].Name)
+				}
+				return
+			}
+			if got.Name != tt.to || st.states[1].Name != tt.to {
+				t.Errorf("moved to %q, stored %q, want %q", got.Name, st.states[1].Name, tt.to)
+			}
+			var data map[string]string
+			if err := Decode(got, &data); err != nil || data["code"] != "ABC" {
+				t.Errorf("data is %v: %v", data, err)
+			}
+		})
+	}
+}
+
+func TestTransitionToUnknownState(t *testing.T) {
+	m, _ := newTestMachine()
+	m.Allow(Initial, "gone")
+	if _, err := m.Transition(context.Background(), 1, "gone", nil); err == nil || errors.Is(err, ErrTransition) {
+		t.Errorf("got %v", err)
+	}
+}
@@ -3,11 +3,11 @@ module vybar
 go 1.14
 
 require (
-	github.com/aws/aws-sdk-go-v2 v0.23.0 // indirect
-	github.com/davecgh/go-spew v1.1.1
-	github.com/google/uuid v1.1.1 // indirect
-	github.com/kelseyhightower/envconfig v1.4.0 // indirect
+	github.com/aws/aws-sdk-go-v2 v0.23.0
+	github.com/google/uuid v1.1.1
+	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/sirupsen/logrus v1.6.0
-	github.com/speps/go-hashids v2.0.0+incompatible // indirect
+	github.com/speps/go-hashids v2.0.0+incompatible
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/sys v0.0.0-20200625212154-ddb9806d33ae // indirect
 )
@@ -0,0 +1,75 @@
+package moderation
+
+import (
+	"fmt"
+	"sort"
+	"vybar/store"
+)
+
+// Policy decides how many agreeing verdicts are needed to accept a
+// submission and how many reviewers may look at it before it is escalated
+// to a coordinator.
+type Policy struct {
+	Quorum    int
+	Reviewers int
+}
+
+func NewPolicy(quorum, reviewers int) (*Policy, error) {
+	if quorum < 1 {
+		return nil, fmt.Errorf("moderation: quorum must be positive, got %d", quorum)
+	}
+	if reviewers < quorum {
+		return nil, fmt.Errorf("moderation: %d reviewers can not reach quorum of %d", reviewers, quorum)
+	}
+	return &Policy{
+		Quorum:    quorum,
+		Reviewers: reviewers,
+	}, nil
+}
+
+func (p *Policy) Decide(sub *store.Submission, verdicts []*store.Verdict) {
+	for _, v := range verdicts {
+		if v.Final {
+			sub.Status = store.StatusAccepted
+			sub.Result = v.Option
+			return
+		}
+	}
+
+	tally := Count(verdicts)
+	if len(tally) > 0 && tally[0].Count >= p.Quorum && (len(tally) == 1 || tally[1].Count < tally[0].Count) {
+		sub.Status = store.StatusAccepted
+		sub.Result = tally[0].Option
+		return
+	}
+
+	if len(verdicts) >= p.Reviewers {
+		sub.Status = store.StatusEscalated
+		return
+	}
+	sub.Status = store.StatusPending
+}
+
+type OptionCount struct {
+	Option string
+	Count  int
+}
+
+// Count groups verdicts by option, the most popular option goes first.
+func Count(verdicts []*store.Verdict) []OptionCount {
+	counts := make(map[string]int)
+	for _, v := range verdicts {
+		counts[v.Option]++
+	}
+	res := make([]OptionCount, 0, len(counts))
+	for opt, cnt := range counts {
+		res = append(res, OptionCount{Option: opt, Count: cnt})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Option < res[j].Option
+	})
+	return res
+}
@@ -0,0 +1,144 @@
+package moderation
+
+import (
+	"testing"
+	"vybar/store"
+)
+
+func verdicts(options ...string) []*store.Verdict {
+	res := make([]*store.Verdict, 0, len(options))
+	for i, opt := range options {
+		res = append(res, &store.Verdict{
+			SubmissionID: 1,
+			ReviewerID:   int64(i + 1),
+			Option:       opt,
+		})
+	}
+	return res
+}
+
+func final(vs []*store.Verdict, option string) []*store.Verdict {
+	return append(vs, &store.Verdict{
+		SubmissionID: 1,
+		ReviewerID:   100,
+		Option:       option,
+		Final:        true,
+	})
+}
+
+func TestDecide(t *testing.T) {
+	tests := []struct {
+		name       string
+		quorum     int
+		reviewers  int
+		verdicts   []*store.Verdict
+		wantStatus store.SubmissionStatus
+		wantResult string
+	}{
+		{
+			name:       "no verdicts",
+			quorum:     2,
+			reviewers:  3,
+			wantStatus: store.StatusPending,
+		},
+		{
+			name:       "below quorum",
+			quorum:     2,
+			reviewers:  3,
+			verdicts:   verdicts("a"),
+			wantStatus: store.StatusPending,
+		},
+		{
+			name:       "quorum",
+			quorum:     2,
+			reviewers:  3,
+			verdicts:   verdicts("a", "a"),
+			wantStatus: store.StatusAccepted,
+			wantResult: "a",
+		},
+		{
+			name:       "tie waits for another reviewer",
+			quorum:     2,
+			reviewers:  3,
+			verdicts:   verdicts("a", "b"),
+			wantStatus: store.StatusPending,
+		},
+		{
+			name:       "majority after a tie",
+			quorum:     2,
+			reviewers:  3,
+			verdicts:   verdicts("a", "b", "b"),
+			wantStatus: store.StatusAccepted,
+			wantResult: "b",
+		},
+		{
+			name:       "tie at quorum",
+			quorum:     1,
+			reviewers:  2,
+			verdicts:   verdicts("a", "b"),
+			wantStatus: store.StatusEscalated,
+		},
+		{
+			name:       "no agreement",
+			quorum:     2,
+			reviewers:  3,
+			verdicts:   verdicts("a", "b", "c"),
+			wantStatus: store.StatusEscalated,
+		},
+		{
+			name:       "tie of two pairs",
+			quorum:     2,
+			reviewers:  4,
+			verdicts:   verdicts("a", "b", "a", "b"),
+			wantStatus: store.StatusEscalated,
+		},
+		{
+			name:       "final verdict",
+			quorum:     2,
+			reviewers:  3,
+			verdicts:   final(verdicts("a", "b", "c"), "c"),
+			wantStatus: store.StatusAccepted,
+			wantResult: "c",
+		},
+		{
+			name:       "final verdict overrides the majority",
+			quorum:     2,
+			reviewers:  3,
+			verdicts:   final(verdicts("a", "b", "a"), "b"),
+			wantStatus: store.StatusAccepted,
+			wantResult: "b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy(tt.quorum, tt.reviewers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sub := store.Submission{ID: 1, Status: store.StatusPending}
+			p.Decide(&sub, tt.verdicts)
+			if sub.Status != tt.wantStatus || sub.Result != tt.wantResult {
+				t.Errorf("got %s %q, want %s %q", sub.Status, sub.Result, tt.wantStatus, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		quorum    int
+		reviewers int
+		ok        bool
+	}{
+		{quorum: 1, reviewers: 1, ok: true},
+		{quorum: 2, reviewers: 3, ok: true},
+		{quorum: 0, reviewers: 3},
+		{quorum: 3, reviewers: 2},
+	}
+	for _, tt := range tests {
+		_, err := NewPolicy(tt.quorum, tt.reviewers)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewPolicy(%d, %d) = %v", tt.quorum, tt.reviewers, err)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketVoters          = []byte("voters")
+	bucketCodes           = []byte("codes")
+	bucketCodeSeqs        = []byte("code_seqs")
+	bucketSubmissions     = []byte("submissions")
+	bucketVerdicts        = []byte("verdicts")
+	bucketQueue           = []byte("queue")
+	bucketFileSubmissions = []byte("file_submissions")
+	bucketCodeSubmissions = []byte("code_submissions")
+	bucketMeta            = []byte("meta")
+	bucketStates          = []byte("states")
+	bucketPendingUpdates  = []byte("pending_updates")
+
+	keyOffset = []byte("offset")
+)
+
+type BoltStore struct {
+	db *bolt.DB
+}
+
+type BoltOption func(*bolt.Options)
+
+// ReadOnly opens the database for reading only. The file is locked by
+// a running bot, so it has to be stopped or a copy of the file used.
+func ReadOnly() BoltOption {
+	return func(o *bolt.Options) {
+		o.ReadOnly = true
+	}
+}
+
+func NewBoltStore(path string, options ...BoltOption) (*BoltStore, error) {
+	opts := bolt.Options{Timeout: 5 * time.Second}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	db, err := bolt.Open(path, 0600, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ReadOnly {
+		return &BoltStore{db: db}, nil
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{
+			bucketVoters,
+			bucketCodes,
+			bucketCodeSeqs,
+			bucketSubmissions,
+			bucketVerdicts,
+			bucketQueue,
+			bucketFileSubmissions,
+			bucketCodeSubmissions,
+			bucketMeta,
+			bucketStates,
+			bucketPendingUpdates,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) GetVoter(_ context.Context, chatID int64) (*Voter, error) {
+	var v Voter
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketVoters), itob(chatID), &v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *BoltStore) SaveVoter(_ context.Context, v *Voter) error {
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(bucketVoters), itob(v.ChatID), v)
+	})
+}
+
+func (s *BoltStore) NextCodeSeq(_ context.Context, scope string) (int, error) {
+	var seq uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(bucketCodeSeqs).CreateBucketIfNotExists([]byte(scope))
+		if err != nil {
+			return err
+		}
+		seq, err = b.NextSequence()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(seq), nil
+}
+
+func (s *BoltStore) CreateCode(_ context.Context, c *Code) error {
+	if c.IssuedAt.IsZero() {
+		c.IssuedAt = time.Now()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketCodes)
+		key := codeKey(c.Scope, c.Code)
+		if b.Get(key) != nil {
+			return ErrExists
+		}
+		return put(b, key, c)
+	})
+}
+
+func (s *BoltStore) GetCode(_ context.Context, scope, code string) (*Code, error) {
+	var c Code
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketCodes), codeKey(scope, code), &c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *BoltStore) SaveSubmission(_ context.Context, sub *Submission) error {
+	if sub.CreatedAt.IsZero() {
+		sub.CreatedAt = time.Now()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSubmissions)
+		if sub.ID == 0 {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			sub.ID = int64(id)
+		}
+		if sub.FileUniqueID != "" {
+			files := tx.Bucket(bucketFileSubmissions)
+			if id := files.Get([]byte(sub.FileUniqueID)); id != nil && !bytes.Equal(id, itob(sub.ID)) {
+				return ErrExists
+			}
+			if err := files.Put([]byte(sub.FileUniqueID), itob(sub.ID)); err != nil {
+				return err
+			}
+		}
+		if sub.Code != "" {
+			codes := tx.Bucket(bucketCodeSubmissions)
+			key := codeKey(sub.Precinct, sub.Code)
+			if id := codes.Get(key); id != nil && !bytes.Equal(id, itob(sub.ID)) {
+				return ErrExists
+			}
+			if err := codes.Put(key, itob(sub.ID)); err != nil {
+				return err
+			}
+		}
+		return putSubmission(tx, sub)
+	})
+}
+
+func putSubmission(tx *bolt.Tx, sub *Submission) error {
+	if err := put(tx.Bucket(bucketSubmissions), itob(sub.ID), sub); err != nil {
+		return err
+	}
+	if sub.Status == StatusPending {
+		return tx.Bucket(bucketQueue).Put(itob(sub.ID), nil)
+	}
+	return tx.Bucket(bucketQueue).Delete(itob(sub.ID))
+}
+
+func (s *BoltStore) GetSubmission(_ context.Context, id int64) (*Submission, error) {
+	var sub Submission
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketSubmissions), itob(id), &sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *BoltStore) SubmissionByFile(_ context.Context, fileUniqueID string) (*Submission, error) {
+	var sub Submission
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(bucketFileSubmissions).Get([]byte(fileUniqueID))
+		if id == nil {
+			return ErrNotFound
+		}
+		return get(tx.Bucket(bucketSubmissions), id, &sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *BoltStore) SubmissionByCode(_ context.Context, precinct, code string) (*Submission, error) {
+	var sub Submission
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(bucketCodeSubmissions).Get(codeKey(precinct, code))
+		if id == nil {
+			return ErrNotFound
+		}
+		return get(tx.Bucket(bucketSubmissions), id, &sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *BoltStore) Submissions(_ context.Context, status SubmissionStatus) ([]*Submission, error) {
+	res := make([]*Submission, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSubmissions).ForEach(func(_, v []byte) error {
+			var sub Submission
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return err
+			}
+			if status == "" || sub.Status == status {
+				res = append(res, &sub)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *BoltStore) Lease(_ context.Context, reviewerID int64, reviewers int, d time.Duration) (*Submission, error) {
+	var leased *Submission
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		verdicts := tx.Bucket(bucketVerdicts)
+		var candidate *Submission
+		// expired leases are dropped from every submission on the way, they
+		// are written after the loop as the cursor must not see changes
+		expired := make([]*Submission, 0)
+		c := tx.Bucket(bucketQueue).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			var sub Submission
+			if err := get(tx.Bucket(bucketSubmissions), k, &sub); err != nil {
+				return err
+			}
+			if dropExpiredLeases(&sub, now) {
+				expired = append(expired, &sub)
+			}
+			if _, ok := sub.Leases[reviewerID]; ok {
+				candidate = &sub
+				break
+			}
+			if candidate != nil || sub.ChatID == reviewerID {
+				continue
+			}
+			if verdicts.Get(verdictKey(sub.ID, reviewerID, false)) != nil {
+				continue
+			}
+			if sub.Reviews+len(sub.Leases) >= reviewers {
+				continue
+			}
+			candidate = &sub
+		}
+		for _, sub := range expired {
+			if sub != candidate {
+				if err := putSubmission(tx, sub); err != nil {
+					return err
+				}
+			}
+		}
+		if candidate == nil {
+			return ErrNotFound
+		}
+
+		if candidate.Leases == nil {
+			candidate.Leases = make(map[int64]time.Time)
+		}
+		candidate.Leases[reviewerID] = now.Add(d)
+		leased = candidate
+		return putSubmission(tx, candidate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return leased, nil
+}
+
+func (s *BoltStore) AddVerdict(_ context.Context, v *Verdict, decide DecideFunc) (*Submission, error) {
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	var sub Submission
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := get(tx.Bucket(bucketSubmissions), itob(v.SubmissionID), &sub); err != nil {
+			return err
+		}
+		switch {
+		case sub.Status == StatusAccepted:
+			return ErrClosed
+		case v.Final && sub.Status != StatusEscalated:
+			return ErrClosed
+		case !v.Final && sub.Status != StatusPending:
+			return ErrClosed
+		case !v.Final:
+			// the submission may be leased to someone else already
+			if until, ok := sub.Leases[v.ReviewerID]; !ok || !until.After(v.CreatedAt) {
+				return ErrLeaseLost
+			}
+			delete(sub.Leases, v.ReviewerID)
+			sub.Reviews++
+		}
+
+		b := tx.Bucket(bucketVerdicts)
+		prefix := itob(v.SubmissionID)
+		if err := put(b, verdictKey(v.SubmissionID, v.ReviewerID, v.Final), v); err != nil {
+			return err
+		}
+
+		verdicts := make([]*Verdict, 0)
+		c := b.Cursor()
+		for k, data := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, data = c.Next() {
+			var verdict Verdict
+			if err := json.Unmarshal(data, &verdict); err != nil {
+				return err
+			}
+			verdicts = append(verdicts, &verdict)
+		}
+		decide(&sub, verdicts)
+		if sub.Status == StatusAccepted && sub.DecidedAt.IsZero() {
+			sub.DecidedAt = v.CreatedAt
+		}
+		return putSubmission(tx, &sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *BoltStore) Verdicts(_ context.Context, submissionID int64) ([]*Verdict, error) {
+	res := make([]*Verdict, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := itob(submissionID)
+		c := tx.Bucket(bucketVerdicts).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var verdict Verdict
+			if err := json.Unmarshal(v, &verdict); err != nil {
+				return err
+			}
+			res = append(res, &verdict)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *BoltStore) AllVerdicts(_ context.Context) ([]*Verdict, error) {
+	res := make([]*Verdict, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketVerdicts).ForEach(func(_, v []byte) error {
+			var verdict Verdict
+			if err := json.Unmarshal(v, &verdict); err != nil {
+				return err
+			}
+			res = append(res, &verdict)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *BoltStore) GetState(_ context.Context, chatID int64) (*State, error) {
+	var st State
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(bucketStates), itob(chatID), &st)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (s *BoltStore) SaveState(_ context.Context, st *State) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(bucketStates), itob(st.ChatID), st)
+	})
+}
+
+func (s *BoltStore) DeleteState(_ context.Context, chatID int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketStates).Delete(itob(chatID))
+	})
+}
+
+func (s *BoltStore) LoadOffset(_ context.Context) (int, error) {
+	var offset int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		err := get(tx.Bucket(bucketMeta), keyOffset, &offset)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+func (s *BoltStore) SaveOffset(_ context.Context, offset int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(bucketMeta), keyOffset, offset)
+	})
+}
+
+func (s *BoltStore) SavePendingUpdate(_ context.Context, id int, data json.RawMessage) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPendingUpdates).Put(itob(int64(id)), data)
+	})
+}
+
+func (s *BoltStore) PendingUpdates(_ context.Context) ([]json.RawMessage, error) {
+	var res []json.RawMessage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPendingUpdates).ForEach(func(_, v []byte) error {
+			res = append(res, append(json.RawMessage(nil), v...))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *BoltStore) DeletePendingUpdate(_ context.Context, id int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPendingUpdates).Delete(itob(int64(id)))
+	})
+}
+
+// verdictKey keeps a final verdict of a coordinator apart from the regular
+// one they may have given before.
+func verdictKey(submissionID, reviewerID int64, final bool) []byte {
+	key := append(itob(submissionID), itob(reviewerID)...)
+	if final {
+		key = append(key, 1)
+	}
+	return key
+}
+
+// dropExpiredLeases reports whether any lease was dropped.
+func dropExpiredLeases(sub *Submission, now time.Time) bool {
+	dropped := false
+	for id, until := range sub.Leases {
+		if !until.After(now) {
+			delete(sub.Leases, id)
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+func itob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func codeKey(scope, code string) []byte {
+	return []byte(scope + "\x00" + code)
+}
+
+func get(b *bolt.Bucket, key []byte, dst interface{}) error {
+	data := b.Get(key)
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func put(b *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+var (
+	_ Store = (*BoltStore)(nil)
+)
@@ -0,0 +1,319 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *BoltStore {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "vybar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewBoltStore(filepath.Join(dir, "vybar.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+		os.RemoveAll(dir)
+	})
+	return s
+}
+
+// addSubmissions saves pending submissions sent from the chats, in order.
+func addSubmissions(t *testing.T, s *BoltStore, chats ...int64) []*Submission {
+	t.Helper()
+	subs := make([]*Submission, 0, len(chats))
+	for i, chatID := range chats {
+		sub := Submission{
+			ChatID:       chatID,
+			Code:         string(rune('A' + i)),
+			FileUniqueID: string(rune('a' + i)),
+			Status:       StatusPending,
+		}
+		if err := s.SaveSubmission(context.Background(), &sub); err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, &sub)
+	}
+	return subs
+}
+
+type testLease struct {
+	reviewer int64
+	d        time.Duration
+}
+
+func TestLease(t *testing.T) {
+	tests := []struct {
+		name      string
+		chats     []int64
+		before    []testLease
+		reviewer  int64
+		reviewers int
+		// want is the index of the leased submission, -1 for none
+		want int
+	}{
+		{
+			name:      "oldest first",
+			chats:     []int64{1, 2},
+			reviewer:  10,
+			reviewers: 3,
+			want:      0,
+		},
+		{
+			name:      "own submission skipped",
+			chats:     []int64{10, 2},
+			reviewer:  10,
+			reviewers: 3,
+			want:      1,
+		},
+		{
+			name:      "own lease returned again",
+			chats:     []int64{1, 2},
+			before:    []testLease{{10, time.Hour}},
+			reviewer:  10,
+			reviewers: 1,
+			want:      0,
+		},
+		{
+			name:      "submission with enough reviewers skipped",
+			chats:     []int64{1, 2},
+			before:    []testLease{{20, time.Hour}},
+			reviewer:  10,
+			reviewers: 1,
+			want:      1,
+		},
+		{
+			name:      "expired lease dropped",
+			chats:     []int64{1, 2},
+			before:    []testLease{{20, -time.Second}},
+			reviewer:  10,
+			reviewers: 1,
+			want:      0,
+		},
+		{
+			name:      "nothing to review",
+			chats:     []int64{10},
+			reviewer:  10,
+			reviewers: 3,
+			want:      -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			ctx := context.Background()
+			subs := addSubmissions(t, s, tt.chats...)
+			for _, l := range tt.before {
+				if _, err := s.Lease(ctx, l.reviewer, tt.reviewers, l.d); err != nil {
+					t.Fatalf("lease to %d: %s", l.reviewer, err)
+				}
+			}
+
+			got, err := s.Lease(ctx, tt.reviewer, tt.reviewers, time.Hour)
+			if tt.want < 0 {
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("got %v, %v, want ErrNotFound", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != subs[tt.want].ID {
+				t.Errorf("leased submission %d, want %d", got.ID, subs[tt.want].ID)
+			}
+			if until, ok := got.Leases[tt.reviewer]; !ok || !until.After(time.Now()) {
+				t.Errorf("lease of the reviewer is %v", got.Leases)
+			}
+		})
+	}
+}
+
+func TestLeaseDropsExpiredLeasesOnTheWay(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	subs := addSubmissions(t, s, 10, 2)
+	if _, err := s.Lease(ctx, 20, 3, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first submission is skipped as the reviewer's own
+	got, err := s.Lease(ctx, 10, 3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != subs[1].ID {
+		t.Fatalf("leased submission %d, want %d", got.ID, subs[1].ID)
+	}
+	skipped, err := s.GetSubmission(ctx, subs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped.Leases) != 0 {
+		t.Errorf("expired leases are kept: %v", skipped.Leases)
+	}
+}
+
+// decideAfter accepts the submission with the option of the last verdict
+// once it has n verdicts, and escalates it when escalate is set.
+func decideAfter(n int, escalate bool) DecideFunc {
+	return func(sub *Submission, verdicts []*Verdict) {
+		switch {
+		case len(verdicts) > 0 && verdicts[len(verdicts)-1].Final:
+			sub.Status = StatusAccepted
+			sub.Result = verdicts[len(verdicts)-1].Option
+		case len(verdicts) < n:
+			sub.Status = StatusPending
+		case escalate:
+			sub.Status = StatusEscalated
+		default:
+			sub.Status = StatusAccepted
+			sub.Result = verdicts[len(verdicts)-1].Option
+		}
+	}
+}
+
+func TestAddVerdict(t *testing.T) {
+	tests := []struct {
+		name   string
+		lease  time.Duration
+		decide DecideFunc
+		// final is sent by the reviewer after the regular verdict
+		final   bool
+		wantErr error
+		// wantSecond is the error of a regular verdict of another reviewer
+		wantSecond error
+	}{
+		{
+			name:   "regular verdict on a lease",
+			lease:  time.Hour,
+			decide: decideAfter(2, false),
+		},
+		{
+			name:    "no lease",
+			decide:  decideAfter(2, false),
+			wantErr: ErrLeaseLost,
+		},
+		{
+			name:    "expired lease",
+			lease:   -time.Second,
+			decide:  decideAfter(2, false),
+			wantErr: ErrLeaseLost,
+		},
+		{
+			name:       "decided submission",
+			lease:      time.Hour,
+			decide:     decideAfter(1, false),
+			wantSecond: ErrClosed,
+		},
+		{
+			name:    "final verdict on a pending submission",
+			lease:   time.Hour,
+			decide:  decideAfter(2, false),
+			final:   true,
+			wantErr: ErrClosed,
+		},
+		{
+			name:       "escalated submission",
+			lease:      time.Hour,
+			decide:     decideAfter(1, true),
+			final:      true,
+			wantSecond: ErrClosed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			ctx := context.Background()
+			sub := addSubmissions(t, s, 1)[0]
+			if tt.lease != 0 {
+				if _, err := s.Lease(ctx, 10, 3, tt.lease); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			v := Verdict{SubmissionID: sub.ID, ReviewerID: 10, Option: "a"}
+			_, err := s.AddVerdict(ctx, &v, tt.decide)
+			if tt.final && err == nil {
+				f := Verdict{SubmissionID: sub.ID, ReviewerID: 10, Option: "b", Final: true}
+				_, err = s.AddVerdict(ctx, &f, tt.decide)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// decided submissions leave the queue, so there may be no lease
+			if _, err := s.Lease(ctx, 20, 3, time.Hour); err != nil && !errors.Is(err, ErrNotFound) {
+				t.Fatal(err)
+			}
+			second := Verdict{SubmissionID: sub.ID, ReviewerID: 20, Option: "a"}
+			_, err = s.AddVerdict(ctx, &second, tt.decide)
+			if !errors.Is(err, tt.wantSecond) {
+				t.Errorf("second verdict: got %v, want %v", err, tt.wantSecond)
+			}
+		})
+	}
+}
+
+func TestFinalVerdictKeptApart(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	sub := addSubmissions(t, s, 1)[0]
+	if _, err := s.Lease(ctx, 10, 3, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	v := Verdict{SubmissionID: sub.ID, ReviewerID: 10, Option: "a"}
+	if _, err := s.AddVerdict(ctx, &v, decideAfter(1, true)); err != nil {
+		t.Fatal(err)
+	}
+	f := Verdict{SubmissionID: sub.ID, ReviewerID: 10, Option: "b", Final: true}
+	got, err := s.AddVerdict(ctx, &f, decideAfter(1, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusAccepted || got.Result != "b" {
+		t.Errorf("got %s %q, want accepted \"b\"", got.Status, got.Result)
+	}
+
+	verdicts, err := s.Verdicts(ctx, sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verdicts) != 2 || verdicts[0].Final || verdicts[0].Option != "a" || !verdicts[1].Final || verdicts[1].Option != "b" {
+		t.Errorf("verdicts are %+v, %+v", verdicts[0], verdicts[len(verdicts)-1])
+	}
+}
+
+func TestLeaseSkipsReviewedSubmissions(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	subs := addSubmissions(t, s, 1, 2)
+	if _, err := s.Lease(ctx, 10, 3, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	v := Verdict{SubmissionID: subs[0].ID, ReviewerID: 10, Option: "a"}
+	if _, err := s.AddVerdict(ctx, &v, decideAfter(2, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Lease(ctx, 10, 3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != subs[1].ID {
+		t.Errorf("leased submission %d, want %d", got.ID, subs[1].ID)
+	}
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("store: not found")
+	ErrExists    = errors.New("store: already exists")
+	ErrLeaseLost = errors.New("store: lease is held by another reviewer")
+	ErrClosed    = errors.New("store: submission is already decided")
+)
+
+type SubmissionStatus string
+
+const (
+	StatusPending   SubmissionStatus = "pending"
+	StatusEscalated SubmissionStatus = "escalated"
+	StatusAccepted  SubmissionStatus = "accepted"
+)
+
+type Voter struct {
+	ChatID        int64     `json:"chat_id"`
+	Code          string    `json:"code,omitempty"`
+	Precinct      string    `json:"precinct,omitempty"`
+	CodeMessageID int       `json:"code_message_id,omitempty"`
+	Blocked       bool      `json:"blocked,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type Code struct {
+	Code     string    `json:"code"`
+	Seq      int       `json:"seq"`
+	Scope    string    `json:"scope"`
+	Election string    `json:"election"`
+	Precinct string    `json:"precinct,omitempty"`
+	ChatID   int64     `json:"chat_id"`
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+type Submission struct {
+	ID           int64     `json:"id"`
+	ChatID       int64     `json:"chat_id"`
+	Code         string    `json:"code"`
+	Precinct     string    `json:"precinct,omitempty"`
+	FileID       string    `json:"file_id"`
+	FileUniqueID string    `json:"file_unique_id"`
+	Media        string    `json:"media,omitempty"` // kind FileID must be sent as, empty for videos
+	Size         int       `json:"size,omitempty"`
+	Duration     int       `json:"duration,omitempty"`
+	Path         string    `json:"path"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	Status    SubmissionStatus    `json:"status"`
+	Result    string              `json:"result,omitempty"`
+	Reviews   int                 `json:"reviews"`
+	Leases    map[int64]time.Time `json:"leases,omitempty"`
+	DecidedAt time.Time           `json:"decided_at"`
+}
+
+type Verdict struct {
+	SubmissionID int64     `json:"submission_id"`
+	ReviewerID   int64     `json:"reviewer_id"`
+	Option       string    `json:"option"`
+	Final        bool      `json:"final,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// State is the step of the conversation with a chat.
+type State struct {
+	ChatID    int64           `json:"chat_id"`
+	Name      string          `json:"name"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	EnteredAt time.Time       `json:"entered_at"`
+	// ExpiresAt is zero for states without a timeout.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// DecideFunc updates status and result of the submission after a new
+// verdict was added. It is called within the same transaction.
+type DecideFunc func(sub *Submission, verdicts []*Verdict)
+
+type Store interface {
+	GetVoter(ctx context.Context, chatID int64) (*Voter, error)
+	SaveVoter(ctx context.Context, v *Voter) error
+
+	// NextCodeSeq returns the next number from the monotonic sequence of the scope.
+	NextCodeSeq(ctx context.Context, scope string) (int, error)
+	// CreateCode fails with ErrExists if the code was already issued in its scope.
+	CreateCode(ctx context.Context, c *Code) error
+	GetCode(ctx context.Context, scope, code string) (*Code, error)
+
+	// SaveSubmission assigns a new ID to the submission if it has none.
+	// Pending submissions are put into the moderation queue. It fails with
+	// ErrExists if another submission has the same FileUniqueID or the same
+	// code in the precinct, as a code is good for a single ballot.
+	SaveSubmission(ctx context.Context, s *Submission) error
+	GetSubmission(ctx context.Context, id int64) (*Submission, error)
+	SubmissionByFile(ctx context.Context, fileUniqueID string) (*Submission, error)
+	SubmissionByCode(ctx context.Context, precinct, code string) (*Submission, error)
+	// Submissions lists submissions with the status, or all of them if status is empty.
+	Submissions(ctx context.Context, status SubmissionStatus) ([]*Submission, error)
+
+	// Lease hands the oldest pending submission, which was neither sent nor
+	// reviewed by the reviewer and still needs reviewers, to the reviewer for
+	// the given duration. A reviewer holding an active lease gets the same
+	// submission again.
+	Lease(ctx context.Context, reviewerID int64, reviewers int, d time.Duration) (*Submission, error)
+	// AddVerdict records the verdict and lets decide update the submission.
+	// Regular verdicts require a lease on a pending submission and fail with
+	// ErrLeaseLost otherwise, final ones are only accepted for escalated
+	// submissions. Both fail with ErrClosed for decided submissions.
+	AddVerdict(ctx context.Context, v *Verdict, decide DecideFunc) (*Submission, error)
+	Verdicts(ctx context.Context, submissionID int64) ([]*Verdict, error)
+	AllVerdicts(ctx context.Context) ([]*Verdict, error)
+
+	GetState(ctx context.Context, chatID int64) (*State, error)
+	SaveState(ctx context.Context, s *State) error
+	DeleteState(ctx context.Context, chatID int64) error
+
+	// LoadOffset returns the offset of the next telegram update, zero if
+	// nothing was saved yet.
+	LoadOffset(ctx context.Context) (int, error)
+	SaveOffset(ctx context.Context, offset int) error
+
+	// SavePendingUpdate keeps a telegram update which was received but not
+	// handled yet, until DeletePendingUpdate is called once it is handled.
+	// PendingUpdates returns the kept updates in order.
+	SavePendingUpdate(ctx context.Context, id int, data json.RawMessage) error
+	PendingUpdates(ctx context.Context) ([]json.RawMessage, error)
+	DeletePendingUpdate(ctx context.Context, id int) error
+
+	Close() error
+}
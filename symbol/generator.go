@@ -1,25 +1,57 @@
 package symbol
 
 import (
-	"math/rand"
-	"time"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"vybar/store"
 
 	"github.com/speps/go-hashids"
 )
 
 const (
 	alphabet = `АБВГДЕЖИКЛМНОПРСТУФХЦЧШЩЫЭЮЯ123456789()-=%$#@!*[]/\|?✔✖"`
+
+	maxAttempts = 5
+)
+
+var (
+	ErrInvalidCode = errors.New("symbol: invalid code")
+	ErrUnknownCode = errors.New("symbol: code was not issued")
+)
+
+// lookalikes maps characters which are easily confused with the alphabet
+// when a moderator types a code from the ballot.
+var lookalikes = strings.NewReplacer(
+	"A", "А", "B", "В", "E", "Е", "K", "К", "M", "М", "H", "Н",
+	"O", "О", "0", "О", "P", "Р", "C", "С", "T", "Т", "Y", "У", "X", "Х",
+	"✓", "✔", "✗", "✖", "×", "✖",
 )
 
-func init() {
-	rand.Seed(time.Now().Unix())
+type Registry interface {
+	NextCodeSeq(ctx context.Context, scope string) (int, error)
+	CreateCode(ctx context.Context, c *store.Code) error
+	GetCode(ctx context.Context, scope, code string) (*store.Code, error)
 }
 
 type Generator struct {
-	h *hashids.HashID
+	h           *hashids.HashID
+	registry    Registry
+	perPrecinct bool
+}
+
+type Option func(*Generator)
+
+// PerPrecinct makes codes unique within a precinct instead of the whole
+// election. Codes become shorter, but must be verified with the precinct.
+func PerPrecinct() Option {
+	return func(g *Generator) {
+		g.perPrecinct = true
+	}
 }
 
-func New(secret string) (*Generator, error) {
+func New(secret string, registry Registry, options ...Option) (*Generator, error) {
 	cfg := hashids.NewData()
 	cfg.Salt = secret
 	cfg.Alphabet = alphabet
@@ -28,13 +60,89 @@ func New(secret string) (*Generator, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Generator{
-		h: h,
-	}, nil
+	g := Generator{
+		h:        h,
+		registry: registry,
+	}
+	for _, opt := range options {
+		opt(&g)
+	}
+	return &g, nil
+}
+
+func (g *Generator) scope(election, precinct string) string {
+	if g.perPrecinct {
+		return election + "/" + precinct
+	}
+	return election
+}
+
+func (g *Generator) Generate(ctx context.Context, owner int64, election, precinct string) (*store.Code, error) {
+	scope := g.scope(election, precinct)
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		seq, err := g.registry.NextCodeSeq(ctx, scope)
+		if err != nil {
+			return nil, err
+		}
+		code, err := g.h.Encode([]int{seq})
+		if err != nil {
+			return nil, err
+		}
+		c := store.Code{
+			Code:     code,
+			Seq:      seq,
+			Scope:    scope,
+			Election: election,
+			Precinct: precinct,
+			ChatID:   owner,
+		}
+		err = g.registry.CreateCode(ctx, &c)
+		if errors.Is(err, store.ErrExists) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &c, nil
+	}
+	return nil, fmt.Errorf("symbol: failed to issue unique code in %d attempts", maxAttempts)
+}
+
+func (g *Generator) Decode(code string) (int, error) {
+	code = Normalize(code)
+	if code == "" {
+		return 0, ErrInvalidCode
+	}
+	ids, err := g.h.DecodeWithError(code)
+	if err != nil || len(ids) != 1 {
+		return 0, ErrInvalidCode
+	}
+	return ids[0], nil
+}
+
+func (g *Generator) Verify(ctx context.Context, election, precinct, code string) (*store.Code, error) {
+	seq, err := g.Decode(code)
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := g.h.Encode([]int{seq})
+	if err != nil {
+		return nil, err
+	}
+	c, err := g.registry.GetCode(ctx, g.scope(election, precinct), canonical)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrUnknownCode
+		}
+		return nil, err
+	}
+	if precinct != "" && c.Precinct != precinct {
+		return nil, ErrUnknownCode
+	}
+	return c, nil
 }
 
-func (g *Generator) Generate() (string, error) {
-	// TODO: should be calculated
-	someID := rand.Intn(1000)
-	return g.h.Encode([]int{someID})
+func Normalize(code string) string {
+	code = strings.Join(strings.Fields(code), "")
+	return lookalikes.Replace(strings.ToUpper(code))
 }
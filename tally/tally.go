@@ -0,0 +1,143 @@
+package tally
+
+import (
+	"context"
+	"sort"
+	"time"
+	"vybar/election"
+	"vybar/store"
+)
+
+type Source interface {
+	Submissions(ctx context.Context, status store.SubmissionStatus) ([]*store.Submission, error)
+}
+
+type Counts struct {
+	// Votes holds accepted votes per option of the ballots.
+	Votes     map[string]int `json:"votes"`
+	Spoiled   int            `json:"spoiled"`
+	Rejected  int            `json:"rejected"`
+	Unknown   int            `json:"unknown"`
+	Pending   int            `json:"pending"`
+	Escalated int            `json:"escalated"`
+	// Duplicates are accepted submissions of a code which already has one
+	// counted.
+	Duplicates int `json:"duplicates"`
+	Total      int `json:"total"`
+}
+
+func newCounts() *Counts {
+	return &Counts{
+		Votes: make(map[string]int),
+	}
+}
+
+func (c *Counts) Accepted() int {
+	res := 0
+	for _, v := range c.Votes {
+		res += v
+	}
+	return res
+}
+
+type Result struct {
+	Election  string             `json:"election"`
+	At        time.Time          `json:"at"`
+	Overall   *Counts            `json:"overall"`
+	Precincts map[string]*Counts `json:"precincts"`
+}
+
+// PrecinctIDs returns identifiers of precincts having submissions in
+// a stable order.
+func (r *Result) PrecinctIDs() []string {
+	res := make([]string, 0, len(r.Precincts))
+	for id := range r.Precincts {
+		res = append(res, id)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (r *Result) Precinct(id string) *Counts {
+	if c, ok := r.Precincts[id]; ok {
+		return c
+	}
+	return newCounts()
+}
+
+// Compute counts all submissions from a single read of the source, so the
+// result is a consistent snapshot.
+func Compute(ctx context.Context, src Source, e *election.Election) (*Result, error) {
+	subs, err := src.Submissions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return Count(e, subs, time.Now()), nil
+}
+
+func Count(e *election.Election, subs []*store.Submission, at time.Time) *Result {
+	res := Result{
+		Election:  e.ID,
+		At:        at,
+		Overall:   newCounts(),
+		Precincts: make(map[string]*Counts),
+	}
+	counted := countedByCode(subs)
+	for _, sub := range subs {
+		p, ok := res.Precincts[sub.Precinct]
+		if !ok {
+			p = newCounts()
+			res.Precincts[sub.Precinct] = p
+		}
+		for _, c := range []*Counts{res.Overall, p} {
+			c.add(e, sub, counted)
+		}
+	}
+	return &res
+}
+
+// countedByCode picks the earliest accepted submission of every code, as
+// a code is given for a single ballot.
+func countedByCode(subs []*store.Submission) map[string]int64 {
+	res := make(map[string]int64)
+	for _, sub := range subs {
+		if sub.Status != store.StatusAccepted {
+			continue
+		}
+		key := sub.Precinct + "\x00" + sub.Code
+		if id, ok := res[key]; !ok || sub.ID < id {
+			res[key] = sub.ID
+		}
+	}
+	return res
+}
+
+func (c *Counts) add(e *election.Election, sub *store.Submission, counted map[string]int64) {
+	c.Total++
+	switch sub.Status {
+	case store.StatusPending:
+		c.Pending++
+		return
+	case store.StatusEscalated:
+		c.Escalated++
+		return
+	}
+	if counted[sub.Precinct+"\x00"+sub.Code] != sub.ID {
+		c.Duplicates++
+		return
+	}
+
+	choice, ok := e.Choice(sub.Result)
+	if !ok {
+		c.Unknown++
+		return
+	}
+	switch choice.Kind {
+	case election.KindSpoiled:
+		c.Spoiled++
+	case election.KindInvalid:
+		c.Rejected++
+	default:
+		c.Votes[choice.ID]++
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"time"
 	"vybar/tg/file"
 	"vybar/tg/message"
 	"vybar/tg/user"
@@ -19,18 +22,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var (
-	tgBaseURL *url.URL
+const (
+	defaultBaseURL = "https://api.telegram.org"
 )
 
-func init() {
-	u, err := url.Parse("https://api.telegram.org")
-	if err != nil {
-		panic(err)
-	}
-	tgBaseURL = u
-}
-
 type Logger interface {
 	Debugf(format string, args ...interface{})
 	Errorf(format string, args ...interface{})
@@ -42,6 +37,34 @@ type API struct {
 	httpClient *http.Client
 	logger     Logger
 	botData    BotUser
+
+	rawBaseURL  string
+	rawMirrors  []string
+	rawProxy    string
+	endpoints   []*url.URL
+	active      int32
+	proxy       *url.URL
+	serverFiles string
+	localFiles  string
+
+	pollTimeout    time.Duration
+	allowedUpdates []string
+	offsetStore    OffsetStore
+	updateStore    UpdateStore
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	maxRetries     int
+	maxRetryAfter  time.Duration
+
+	requestTimeout time.Duration
+	fileTimeout    time.Duration
+	connectTimeout time.Duration
+
+	globalRate float64
+	chatRate   float64
+	chatBurst  int
+	scheduler  *scheduler
+	stop       context.CancelFunc
 }
 
 type Option func(*API)
@@ -52,6 +75,57 @@ func HTTPClient(c *http.Client) Option {
 	}
 }
 
+// BaseURL points the client at another Bot API server, e.g. a self-hosted
+// telegram-bot-api, which is not limited to 20 MB files.
+func BaseURL(u string) Option {
+	return func(a *API) {
+		a.rawBaseURL = u
+	}
+}
+
+// LocalFiles makes the client read files from disk when a Bot API server
+// running with --local returns absolute file paths. Paths under serverDir are
+// looked up under localDir, which is where the server directory is mounted
+// for the bot. Leave both empty when the bot shares the filesystem with the
+// server.
+func LocalFiles(serverDir, localDir string) Option {
+	return func(a *API) {
+		a.serverFiles = serverDir
+		a.localFiles = localDir
+	}
+}
+
+// RetryPolicy limits how many times and how long the client waits when
+// telegram answers with 429 Too Many Requests.
+func RetryPolicy(maxRetries int, maxRetryAfter time.Duration) Option {
+	return func(a *API) {
+		a.maxRetries = maxRetries
+		a.maxRetryAfter = maxRetryAfter
+	}
+}
+
+// Timeouts limits every attempt of an API call to request and every file
+// transfer to file unless the context passed to the call expires earlier.
+// Waiting for a retry after flood control is not counted. Zero turns a limit
+// off.
+func Timeouts(request, file time.Duration) Option {
+	return func(a *API) {
+		a.requestTimeout = request
+		a.fileTimeout = file
+	}
+}
+
+// SendRate limits outgoing messages to global per second overall and
+// perChat per second for every chat, allowing bursts of chatBurst messages to
+// the same chat. A zero global rate turns the limiter off.
+func SendRate(global, perChat float64, chatBurst int) Option {
+	return func(a *API) {
+		a.globalRate = global
+		a.chatRate = perChat
+		a.chatBurst = chatBurst
+	}
+}
+
 func WithLogger(logger Logger) Option {
 	return func(a *API) {
 		a.logger = logger
@@ -60,23 +134,102 @@ func WithLogger(logger Logger) Option {
 
 func New(token string, options ...Option) (*API, error) {
 	api := API{
-		token:      token,
-		httpClient: http.DefaultClient,
-		logger:     logrus.StandardLogger(),
+		token:       token,
+		rawBaseURL:  defaultBaseURL,
+		httpClient:  http.DefaultClient,
+		logger:      logrus.StandardLogger(),
+		pollTimeout: 30 * time.Second,
+		minBackoff:  time.Second,
+		maxBackoff:  time.Minute,
+
+		maxRetries:    3,
+		maxRetryAfter: time.Minute,
+
+		requestTimeout: 30 * time.Second,
+		fileTimeout:    10 * time.Minute,
+		connectTimeout: 10 * time.Second,
+
+		globalRate: defaultGlobalRate,
+		chatRate:   defaultChatRate,
+		chatBurst:  defaultChatBurst,
 	}
 
 	for _, opt := range options {
 		opt(&api)
 	}
 
+	if err := api.setupRoutes(); err != nil {
+		return nil, err
+	}
+
+	if api.globalRate > 0 && api.chatRate > 0 {
+		if api.chatBurst < 1 {
+			api.chatBurst = 1
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		api.scheduler = newScheduler(api.globalRate, api.chatRate, api.chatBurst)
+		api.stop = cancel
+		go api.scheduler.run(ctx)
+	}
+
 	botData, err := api.GetMe()
 	if err != nil {
+		api.Close()
 		return nil, err
 	}
 	api.botData = *botData
+	api.logger.Infof("tg: connected to %s", api.Route())
 	return &api, err
 }
 
+// Close stops the send scheduler. Messages which are still waiting for their
+// turn and those sent after Close fail with ErrClosed.
+func (api *API) Close() {
+	if api.stop != nil {
+		api.stop()
+		<-api.scheduler.done
+	}
+}
+
+// wait blocks until the scheduler allows to send a message to the chat. The
+// returned release must be called once the message is sent.
+func (api *API) wait(ctx context.Context, chatID int64, prio Priority) (func(), error) {
+	if api.scheduler == nil {
+		return func() {}, nil
+	}
+	if err := api.scheduler.wait(ctx, chatID, prio); err != nil {
+		return nil, err
+	}
+	return api.scheduler.release, nil
+}
+
+// withTimeout limits the call by d. The earlier deadline of ctx is kept.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// QueueDepth reports how many messages are waiting to be sent.
+func (api *API) QueueDepth() QueueDepth {
+	if api.scheduler == nil {
+		return QueueDepth{}
+	}
+	return api.scheduler.depth()
+}
+
+// Flush stops taking new messages, they fail with ErrClosed, and waits until
+// the queued ones and those being sent are delivered or ctx is done. Call it
+// on shutdown before cancelling the calls which wait for delivery, and before
+// Close, which stops the queue.
+func (api *API) Flush(ctx context.Context) error {
+	if api.scheduler == nil {
+		return nil
+	}
+	return api.scheduler.drain(ctx)
+}
+
 type BotUser struct {
 	user.User
 	CanJoinGroups           bool `json:"can_join_groups"`
@@ -85,7 +238,8 @@ type BotUser struct {
 }
 
 type tgFile struct {
-	r *http.Response
+	r      *http.Response
+	cancel context.CancelFunc
 }
 
 func (f *tgFile) Read(b []byte) (int, error) {
@@ -93,6 +247,7 @@ func (f *tgFile) Read(b []byte) (int, error) {
 }
 
 func (f *tgFile) Close() error {
+	defer f.cancel()
 	io.Copy(ioutil.Discard, f.r.Body)
 	return f.r.Body.Close()
 }
@@ -108,7 +263,7 @@ func (api *API) newFileRequest(ctx context.Context, filePath string) (*http.Requ
 	}
 
 	u.Path = path.Join("file", fmt.Sprintf("bot%s", api.token), u.Path)
-	u = tgBaseURL.ResolveReference(u)
+	u = api.endpoints[0].ResolveReference(u)
 
 	api.logger.Debugf("tg: GET -> %s", strings.ReplaceAll(u.String(), api.token, "*****"))
 
@@ -137,7 +292,7 @@ func (api *API) newRequest(ctx context.Context, method, relURL string, body inte
 	}
 
 	u.Path = path.Join(fmt.Sprintf("bot%s", api.token), u.Path)
-	u = tgBaseURL.ResolveReference(u)
+	u = api.endpoints[0].ResolveReference(u)
 
 	api.logger.Debugf("tg: %s -> %s", method, strings.ReplaceAll(u.String(), api.token, "*****"))
 
@@ -150,16 +305,132 @@ func (api *API) newRequest(ctx context.Context, method, relURL string, body inte
 	return req, nil
 }
 
+type multipartFile struct {
+	field  string
+	name   string
+	reader io.Reader
+}
+
+// newMultipartRequest streams uploaded files to telegram instead of holding
+// them in memory. Such a request can not be sent again, so it is neither
+// retried on flood control nor failed over to a mirror.
+func (api *API) newMultipartRequest(ctx context.Context, relURL string, fields map[string]string, files []multipartFile) (*http.Request, error) {
+	u, err := url.Parse(relURL)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = path.Join(fmt.Sprintf("bot%s", api.token), u.Path)
+	u = api.endpoints[0].ResolveReference(u)
+
+	write := func(mw *multipart.Writer) error {
+		for k, v := range fields {
+			if err := mw.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+		for _, f := range files {
+			fw, err := mw.CreateFormFile(f.field, f.name)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(fw, f.reader); err != nil {
+				return err
+			}
+		}
+		return mw.Close()
+	}
+
+	var body io.Reader
+	var mw *multipart.Writer
+	if len(files) == 0 {
+		var buf bytes.Buffer
+		mw = multipart.NewWriter(&buf)
+		if err := write(mw); err != nil {
+			return nil, err
+		}
+		body = &buf
+		api.logger.Debugf("tg: POST -> %s (multipart, %d bytes)", strings.ReplaceAll(u.String(), api.token, "*****"), buf.Len())
+	} else {
+		pr, pw := io.Pipe()
+		mw = multipart.NewWriter(pw)
+		// the transport closes the body once it is done with the request,
+		// which stops the writer if the upload fails half way
+		go func() {
+			pw.CloseWithError(write(mw))
+		}()
+		body = pr
+		api.logger.Debugf("tg: POST -> %s (multipart, streamed)", strings.ReplaceAll(u.String(), api.token, "*****"))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req, nil
+}
+
 type tgResponse struct {
-	OK     bool            `json:"ok"`
-	Result json.RawMessage `json:"result"`
+	OK          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result"`
+	ErrorCode   int                 `json:"error_code,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Parameters  *ResponseParameters `json:"parameters,omitempty"`
 }
 
-func (api *API) do(r *http.Request, dst interface{}) error {
-	resp, err := api.httpClient.Do(r)
+// do sends the request, retrying it on flood control unless the retry budget
+// is exhausted or the wait is too long. Every attempt is limited by timeout
+// separately, so waiting for a retry does not eat it up.
+func (api *API) do(r *http.Request, timeout time.Duration, dst interface{}) error {
+	for attempt := 0; ; attempt++ {
+		err := api.doOnce(r, timeout, dst)
+		e, ok := AsError(err)
+		if !ok || e.Code != http.StatusTooManyRequests || e.RetryAfter <= 0 {
+			return err
+		}
+		if attempt >= api.maxRetries || e.RetryAfter > api.maxRetryAfter {
+			return err
+		}
+
+		next, cerr := rewind(r)
+		if cerr != nil {
+			return err
+		}
+		api.logger.Infof("tg: flood control, retry in %s", e.RetryAfter)
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-time.After(e.RetryAfter):
+		}
+		r = next
+	}
+}
+
+// rewind makes a copy of the request which can be sent again.
+func rewind(r *http.Request) (*http.Request, error) {
+	next := r.Clone(r.Context())
+	if r.Body == nil || r.Body == http.NoBody {
+		return next, nil
+	}
+	if r.GetBody == nil {
+		return nil, fmt.Errorf("tg: request body can not be sent again")
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next.Body = body
+	return next, nil
+}
+
+func (api *API) doOnce(r *http.Request, timeout time.Duration, dst interface{}) error {
+	resp, cancel, err := api.send(r, timeout)
 	if err != nil {
 		return err
 	}
+	defer cancel()
 
 	// DEBUG
 	var debugBuf bytes.Buffer
@@ -176,7 +447,11 @@ func (api *API) do(r *http.Request, dst interface{}) error {
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("tg: failed to request telegram api: returned not 200 OK")
+		var rsp tgResponse
+		if err := json.NewDecoder(rdr).Decode(&rsp); err != nil {
+			return newError(resp.StatusCode, nil)
+		}
+		return newError(resp.StatusCode, &rsp)
 	}
 
 	if dst != nil {
@@ -185,7 +460,7 @@ func (api *API) do(r *http.Request, dst interface{}) error {
 			return err
 		}
 		if !rsp.OK {
-			return fmt.Errorf("tg: response not ok")
+			return newError(resp.StatusCode, &rsp)
 		}
 		if err := json.Unmarshal(rsp.Result, dst); err != nil {
 			return err
@@ -196,13 +471,17 @@ func (api *API) do(r *http.Request, dst interface{}) error {
 }
 
 func (api *API) GetMe() (*BotUser, error) {
-	req, err := api.newRequest(context.Background(), "GET", "getMe", nil)
+	return api.GetMeContext(context.Background())
+}
+
+func (api *API) GetMeContext(ctx context.Context) (*BotUser, error) {
+	req, err := api.newRequest(ctx, "GET", "getMe", nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp BotUser
-	if err := api.do(req, &resp); err != nil {
+	if err := api.do(req, api.requestTimeout, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
@@ -212,68 +491,11 @@ func (api *API) Username() string {
 	return *api.botData.Username
 }
 
-type Update struct {
-	ID      int              `json:"update_id"`
-	Message *message.Message `json:"message"`
-}
-
-func (api *API) GetUpdatesContext(ctx context.Context, offset int) ([]*Update, error) {
-	prms := make(url.Values)
-	// prms.Add("timeout", "600")
-	if offset != 0 {
-		prms.Add("offset", strconv.Itoa(offset))
-	}
-	u := &url.URL{
-		Path:     "getUpdates",
-		RawQuery: prms.Encode(),
-	}
-	req, err := api.newRequest(ctx, "GET", u.String(), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	var res []*Update
-	if err := api.do(req, &res); err != nil {
-		return nil, err
-	}
-
-	return res, nil
-}
-
-func (api *API) GetUpdatesChan(ctx context.Context, offset int) (<-chan Update, error) {
-	result := make(chan Update)
-
-	go func() {
-		defer close(result)
-		for {
-			upds, err := api.GetUpdatesContext(ctx, offset)
-			if err != nil {
-				api.logger.Errorf("tg: %s", err.Error())
-				return
-			}
-
-			for _, upd := range upds {
-				offset = upd.ID
-				result <- *upd
-			}
-			if len(upds) > 0 {
-				offset++
-			}
-
-			select {
-			case <-ctx.Done():
-				api.logger.Infof("tg: stop updates worker: %s", ctx.Err().Error())
-				return
-			default:
-				continue
-			}
-		}
-	}()
-
-	return result, nil
+func (api *API) SendMessage(msg *message.Message) (*message.Message, error) {
+	return api.SendMessageContext(context.Background(), msg)
 }
 
-func (api *API) SendMessage(msg *message.Message) (*message.Message, error) {
+func (api *API) SendMessageContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
 	req := struct {
 		ChatID           int64           `json:"chat_id"`
 		Text             string          `json:"text"`
@@ -304,39 +526,79 @@ func (api *API) SendMessage(msg *message.Message) (*message.Message, error) {
 		req.ReplyMarkup = d
 	}
 
-	r, err := api.newRequest(context.Background(), "POST", "sendMessage", &req)
+	release, err := api.wait(ctx, msg.Chat.ID, priority(msg))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	r, err := api.newRequest(ctx, "POST", "sendMessage", &req)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp message.Message
-	if err := api.do(r, &resp); err != nil {
+	if err := api.do(r, api.requestTimeout, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
 
+func (api *API) AnswerCallbackQuery(queryID, text string, showAlert bool) error {
+	return api.AnswerCallbackQueryContext(context.Background(), queryID, text, showAlert)
+}
+
+func (api *API) AnswerCallbackQueryContext(ctx context.Context, queryID, text string, showAlert bool) error {
+	req := struct {
+		CallbackQueryID string `json:"callback_query_id"`
+		Text            string `json:"text,omitempty"`
+		ShowAlert       bool   `json:"show_alert,omitempty"`
+	}{
+		CallbackQueryID: queryID,
+		Text:            text,
+		ShowAlert:       showAlert,
+	}
+
+	r, err := api.newRequest(ctx, "POST", "answerCallbackQuery", &req)
+	if err != nil {
+		return err
+	}
+
+	var resp bool
+	return api.do(r, api.requestTimeout, &resp)
+}
+
 func (api *API) GetFile(fileID string) (*file.File, error) {
+	return api.GetFileContext(context.Background(), fileID)
+}
+
+func (api *API) GetFileContext(ctx context.Context, fileID string) (*file.File, error) {
 	req := struct {
 		FileID string `json:"file_id"`
 	}{
 		FileID: fileID,
 	}
 
-	r, err := api.newRequest(context.Background(), "POST", "getFile", &req)
+	r, err := api.newRequest(ctx, "POST", "getFile", &req)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp file.File
-	if err := api.do(r, &resp); err != nil {
+	if err := api.do(r, api.requestTimeout, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
 
 func (api *API) GetFD(fileID string) (io.ReadCloser, error) {
-	f, err := api.GetFile(fileID)
+	return api.GetFDContext(context.Background(), fileID)
+}
+
+// GetFDContext opens the file for reading. The file timeout covers the whole
+// download, until the reader is closed.
+func (api *API) GetFDContext(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	f, err := api.GetFileContext(ctx, fileID)
 	if err != nil {
 		return nil, err
 	}
@@ -345,14 +607,38 @@ func (api *API) GetFD(fileID string) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("tg: telegram servers does not return file_path")
 	}
 
-	req, err := api.newFileRequest(context.Background(), *f.FilePath)
+	if filepath.IsAbs(*f.FilePath) {
+		return api.openLocalFile(*f.FilePath)
+	}
+
+	req, err := api.newFileRequest(ctx, *f.FilePath)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := api.httpClient.Do(req)
+	resp, cancel, err := api.send(req, api.fileTimeout)
 	if err != nil {
 		return nil, err
 	}
-	return &tgFile{resp}, nil
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		cancel()
+		return nil, newError(resp.StatusCode, nil)
+	}
+	return &tgFile{resp, cancel}, nil
+}
+
+// openLocalFile opens a file downloaded by a local Bot API server.
+func (api *API) openLocalFile(p string) (io.ReadCloser, error) {
+	p = filepath.Clean(p)
+	if api.serverFiles != "" {
+		rel, err := filepath.Rel(filepath.Clean(api.serverFiles), p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("tg: file %q is outside of the server directory", p)
+		}
+		p = filepath.Join(api.localFiles, rel)
+	}
+	api.logger.Debugf("tg: open local file %s", p)
+	return os.Open(p)
 }
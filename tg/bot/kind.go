@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"vybar/tg"
+	"vybar/tg/message"
+)
+
+type Kind string
+
+const (
+	KindMessage       Kind = "message"
+	KindEditedMessage Kind = "edited_message"
+	KindCallbackQuery Kind = "callback_query"
+	KindMyChatMember  Kind = "my_chat_member"
+	KindUnknown       Kind = ""
+)
+
+func KindOf(upd *tg.Update) Kind {
+	switch {
+	case upd.Message != nil:
+		return KindMessage
+	case upd.EditedMessage != nil:
+		return KindEditedMessage
+	case upd.CallbackQuery != nil:
+		return KindCallbackQuery
+	case upd.MyChatMember != nil:
+		return KindMyChatMember
+	}
+	return KindUnknown
+}
+
+type Media string
+
+const (
+	MediaPhoto     Media = "photo"
+	MediaVideo     Media = "video"
+	MediaVideoNote Media = "video_note"
+	MediaAnimation Media = "animation"
+	MediaDocument  Media = "document"
+	MediaLocation  Media = "location"
+	MediaContact   Media = "contact"
+)
+
+func HasMedia(msg *message.Message, kind Media) bool {
+	switch kind {
+	case MediaPhoto:
+		return len(msg.Photo) > 0
+	case MediaVideo:
+		return msg.Video != nil
+	case MediaVideoNote:
+		return msg.VideoNote != nil
+	case MediaAnimation:
+		return msg.Animation != nil
+	case MediaDocument:
+		return msg.Document != nil
+	case MediaLocation:
+		return msg.Location != nil
+	case MediaContact:
+		return msg.Contact != nil
+	}
+	return false
+}
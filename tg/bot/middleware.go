@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+	"vybar/tg"
+)
+
+// Logging logs every update with the time it took to handle.
+func Logging(logger tg.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, upd *tg.Update) error {
+			start := time.Now()
+			err := next(ctx, upd)
+			logger.Debugf("bot: %s %d from %d handled in %s", KindOf(upd), upd.ID, ChatID(upd), time.Since(start))
+			return err
+		}
+	}
+}
+
+// Recover turns a panic in a handler into an error, so one bad update does
+// not stop the bot.
+func Recover(logger tg.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, upd *tg.Update) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					logger.Errorf("bot: panic while handling update %d: %v\n%s", upd.ID, p, debug.Stack())
+					err = fmt.Errorf("bot: panic while handling update %d: %v", upd.ID, p)
+				}
+			}()
+			return next(ctx, upd)
+		}
+	}
+}
+
+// Only passes updates which are allowed to the handler and silently drops
+// the rest, e.g. to restrict commands to coordinators.
+func Only(allow func(upd *tg.Update) bool) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, upd *tg.Update) error {
+			if !allow(upd) {
+				return nil
+			}
+			return next(ctx, upd)
+		}
+	}
+}
+
+// Except applies the middleware to all updates but the matching ones.
+func Except(match Matcher, mw Middleware) Middleware {
+	return func(next Handler) Handler {
+		wrapped := mw(next)
+		return func(ctx context.Context, upd *tg.Update) error {
+			if match(upd) {
+				return next(ctx, upd)
+			}
+			return wrapped(ctx, upd)
+		}
+	}
+}
+
+type allowance struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimit drops updates from chats which send more than rate updates per
+// second, allowing bursts of burst updates. Dropped updates get no answer,
+// so exempt those which must not be lost with Except.
+func RateLimit(rate float64, burst int, logger tg.Logger) Middleware {
+	var mu sync.Mutex
+	chats := make(map[int64]*allowance)
+	cleaned := time.Now()
+
+	allow := func(chatID int64, now time.Time) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		a, ok := chats[chatID]
+		if !ok {
+			a = &allowance{
+				tokens: float64(burst),
+				last:   now,
+			}
+			chats[chatID] = a
+		}
+		a.tokens += now.Sub(a.last).Seconds() * rate
+		if a.tokens > float64(burst) {
+			a.tokens = float64(burst)
+		}
+		a.last = now
+
+		// forget chats which are quiet long enough to get a full burst back
+		if now.Sub(cleaned) > time.Minute {
+			for id, c := range chats {
+				if now.Sub(c.last).Seconds()*rate >= float64(burst) {
+					delete(chats, id)
+				}
+			}
+			cleaned = now
+		}
+
+		if a.tokens < 1 {
+			return false
+		}
+		a.tokens--
+		return true
+	}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, upd *tg.Update) error {
+			chatID := ChatID(upd)
+			if chatID != 0 && !allow(chatID, time.Now()) {
+				logger.Infof("bot: drop update %d from %d, too many requests", upd.ID, chatID)
+				return nil
+			}
+			return next(ctx, upd)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"sync"
+	"vybar/tg"
+)
+
+// pool handles updates of different chats in parallel. Every chat with
+// queued updates is owned by one worker which handles them in order, so a
+// chat never sees its updates handled out of order or at the same time.
+type pool struct {
+	serve   func(upd *tg.Update)
+	slots   chan struct{}
+	backlog int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	chats   map[int64][]*tg.Update
+	pending int
+	wg      sync.WaitGroup
+}
+
+func newPool(workers, backlog int, serve func(upd *tg.Update)) *pool {
+	if backlog < workers {
+		backlog = workers
+	}
+	p := pool{
+		serve:   serve,
+		slots:   make(chan struct{}, workers),
+		backlog: backlog,
+		chats:   make(map[int64][]*tg.Update),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return &p
+}
+
+// push queues the update of the chat. It blocks while the backlog is full or
+// while all workers are busy with other chats.
+func (p *pool) push(chatID int64, upd *tg.Update) {
+	p.mu.Lock()
+	for p.pending >= p.backlog {
+		p.cond.Wait()
+	}
+	p.pending++
+	if q, ok := p.chats[chatID]; ok {
+		// the chat already has a worker, it picks the update up
+		p.chats[chatID] = append(q, upd)
+		p.mu.Unlock()
+		return
+	}
+	p.chats[chatID] = []*tg.Update{upd}
+	p.mu.Unlock()
+
+	p.slots <- struct{}{}
+	p.wg.Add(1)
+	go p.work(chatID)
+}
+
+func (p *pool) work(chatID int64) {
+	defer p.wg.Done()
+	defer func() { <-p.slots }()
+
+	for {
+		p.mu.Lock()
+		q := p.chats[chatID]
+		if len(q) == 0 {
+			delete(p.chats, chatID)
+			p.mu.Unlock()
+			return
+		}
+		upd := q[0]
+		q[0] = nil
+		p.chats[chatID] = q[1:]
+		p.mu.Unlock()
+
+		p.serve(upd)
+
+		p.mu.Lock()
+		p.pending--
+		p.cond.Signal()
+		p.mu.Unlock()
+	}
+}
+
+// wait blocks until all queued updates are handled.
+func (p *pool) wait() {
+	p.wg.Wait()
+}
@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"vybar/tg"
+)
+
+func TestPoolOrderWithinChat(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		backlog int
+		chats   []int64
+	}{
+		{name: "one chat", workers: 4, backlog: 8, chats: []int64{1, 1, 1, 1, 1}},
+		{name: "interleaved chats", workers: 2, backlog: 2, chats: []int64{1, 2, 1, 3, 2, 1, 3, 3, 2, 1}},
+		{name: "more chats than workers", workers: 2, backlog: 4, chats: []int64{1, 2, 3, 4, 5, 1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			busy := make(map[int64]bool)
+			got := make(map[int64][]int)
+			serve := func(upd *tg.Update) {
+				chatID := ChatID(upd)
+				mu.Lock()
+				if busy[chatID] {
+					t.Errorf("updates of chat %d are handled at the same time", chatID)
+				}
+				busy[chatID] = true
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				busy[chatID] = false
+				got[chatID] = append(got[chatID], upd.ID)
+				mu.Unlock()
+			}
+
+			p := newPool(tt.workers, tt.backlog, serve)
+			want := make(map[int64][]int)
+			for i, chatID := range tt.chats {
+				p.push(chatID, chatUpdate(i, chatID))
+				want[chatID] = append(want[chatID], i)
+			}
+			p.wait()
+
+			for chatID, ids := range want {
+				if !equalIDs(got[chatID], ids) {
+					t.Errorf("chat %d handled %v, want %v", chatID, got[chatID], ids)
+				}
+			}
+		})
+	}
+}
+
+func TestPoolChatsInParallel(t *testing.T) {
+	started := make(chan int64, 2)
+	release := make(chan struct{})
+	p := newPool(2, 2, func(upd *tg.Update) {
+		started <- ChatID(upd)
+		<-release
+	})
+	p.push(1, chatUpdate(0, 1))
+	p.push(2, chatUpdate(1, 2))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("a busy chat holds back another one")
+		}
+	}
+	close(release)
+	p.wait()
+}
+
+func chatUpdate(id int, chatID int64) *tg.Update {
+	upd := textUpdate("text")
+	upd.ID = id
+	upd.Message.Chat.ID = chatID
+	return upd
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
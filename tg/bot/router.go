@@ -0,0 +1,260 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"vybar/tg"
+	"vybar/tg/message"
+)
+
+// ErrSkip is returned by a handler which does not want to handle the update
+// after all, so the router tries the next matching route.
+var ErrSkip = errors.New("bot: skip update")
+
+type Handler func(ctx context.Context, upd *tg.Update) error
+
+type Middleware func(Handler) Handler
+
+// Matcher decides whether a route handles the update.
+type Matcher func(upd *tg.Update) bool
+
+type route struct {
+	match   Matcher
+	handler Handler
+}
+
+// Router passes every update to the first route which matches it. Routes are
+// tried in order of registration.
+type Router struct {
+	username    string
+	routes      []route
+	middleware  []Middleware
+	onError     func(err error)
+	onUnhandled func(upd *tg.Update)
+	workers     int
+	backlog     int
+}
+
+type Option func(*Router)
+
+// OnError sets a function which is called with errors returned by handlers
+// in Run. With several workers it is called from several goroutines.
+func OnError(f func(err error)) Option {
+	return func(r *Router) {
+		r.onError = f
+	}
+}
+
+// OnUnhandled sets a function which is called in Run with updates left
+// unhandled because ctx was done, so they can be kept until restart.
+func OnUnhandled(f func(upd *tg.Update)) Option {
+	return func(r *Router) {
+		r.onUnhandled = f
+	}
+}
+
+// Workers makes Run handle updates of up to n chats at once. Updates of one
+// chat are still handled one by one in the order they came. When backlog
+// updates are waiting, Run stops reading the channel, which holds back
+// polling until the workers catch up.
+func Workers(n, backlog int) Option {
+	return func(r *Router) {
+		r.workers = n
+		r.backlog = backlog
+	}
+}
+
+// NewRouter creates a router for the bot with the username. Commands
+// addressed to other bots, like /start@other_bot, are not matched.
+func NewRouter(username string, options ...Option) *Router {
+	r := Router{
+		username:    strings.ToLower(username),
+		onError:     func(err error) {},
+		onUnhandled: func(upd *tg.Update) {},
+	}
+	for _, opt := range options {
+		opt(&r)
+	}
+	return &r
+}
+
+// Use adds middleware which wraps every update, in the order of calls.
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+func (r *Router) Handle(m Matcher, h Handler) {
+	r.routes = append(r.routes, route{
+		match:   m,
+		handler: h,
+	})
+}
+
+// Command handles messages starting with the /command, name is given without
+// the slash.
+func (r *Router) Command(name string, h Handler) {
+	name = strings.ToLower(strings.TrimPrefix(name, "/"))
+	r.Handle(func(upd *tg.Update) bool {
+		if upd.Message == nil {
+			return false
+		}
+		cmd, mention, _ := upd.Message.Command()
+		if mention != "" && strings.ToLower(mention) != r.username {
+			return false
+		}
+		return cmd == name
+	}, h)
+}
+
+// Text handles messages with the text, ignoring case and surrounding spaces.
+func (r *Router) Text(text string, h Handler) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	r.Handle(func(upd *tg.Update) bool {
+		return strings.ToLower(strings.TrimSpace(Text(upd))) == text
+	}, h)
+}
+
+// Regexp handles messages with a text matching re.
+func (r *Router) Regexp(re *regexp.Regexp, h Handler) {
+	r.Handle(func(upd *tg.Update) bool {
+		return upd.Message != nil && upd.Message.Text != nil && re.MatchString(*upd.Message.Text)
+	}, h)
+}
+
+// AnyText handles all text messages.
+func (r *Router) AnyText(h Handler) {
+	r.Handle(func(upd *tg.Update) bool {
+		return upd.Message != nil && upd.Message.Text != nil
+	}, h)
+}
+
+// Callback handles callback queries with data starting with the prefix.
+func (r *Router) Callback(prefix string, h Handler) {
+	r.Handle(func(upd *tg.Update) bool {
+		q := upd.CallbackQuery
+		return q != nil && q.Data != nil && strings.HasPrefix(*q.Data, prefix)
+	}, h)
+}
+
+// Media handles messages carrying the media.
+func (r *Router) Media(kind Media, h Handler) {
+	r.Handle(func(upd *tg.Update) bool {
+		return upd.Message != nil && HasMedia(upd.Message, kind)
+	}, h)
+}
+
+// Kind handles all updates of the kind.
+func (r *Router) Kind(kind Kind, h Handler) {
+	r.Handle(func(upd *tg.Update) bool {
+		return KindOf(upd) == kind
+	}, h)
+}
+
+func (r *Router) dispatch(ctx context.Context, upd *tg.Update) error {
+	for _, rt := range r.routes {
+		if !rt.match(upd) {
+			continue
+		}
+		err := rt.handler(ctx, upd)
+		if errors.Is(err, ErrSkip) {
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+// Serve passes the update through middleware to the matching route.
+func (r *Router) Serve(ctx context.Context, upd *tg.Update) error {
+	h := Handler(r.dispatch)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h(ctx, upd)
+}
+
+// Run serves updates until the channel is closed and all of them are
+// handled. Without Workers updates are handled one by one. Once ctx is done,
+// the rest of updates and those whose handlers failed are passed to
+// OnUnhandled instead, as are updates whose handlers failed with
+// tg.ErrClosed because the client was flushed on shutdown.
+func (r *Router) Run(ctx context.Context, updates <-chan tg.Update) {
+	serve := func(upd *tg.Update) {
+		if ctx.Err() != nil {
+			r.onUnhandled(upd)
+			return
+		}
+		err := r.Serve(ctx, upd)
+		if err == nil || errors.Is(err, ErrSkip) {
+			return
+		}
+		if Interrupted(ctx, err) {
+			r.onUnhandled(upd)
+			return
+		}
+		r.onError(err)
+	}
+	if r.workers <= 1 {
+		for upd := range updates {
+			upd := upd
+			serve(&upd)
+		}
+		return
+	}
+
+	p := newPool(r.workers, r.backlog, serve)
+	for upd := range updates {
+		upd := upd
+		p.push(ChatID(&upd), &upd)
+	}
+	p.wait()
+}
+
+// Interrupted reports whether the handler failed because of shutdown rather
+// than because of the update.
+func Interrupted(ctx context.Context, err error) bool {
+	return err != nil && (ctx.Err() != nil || errors.Is(err, tg.ErrClosed))
+}
+
+// Text returns the text of the message in the update or an empty string.
+func Text(upd *tg.Update) string {
+	if upd.Message == nil || upd.Message.Text == nil {
+		return ""
+	}
+	return *upd.Message.Text
+}
+
+// Args returns the text after the command.
+func Args(upd *tg.Update) string {
+	if upd.Message == nil {
+		return ""
+	}
+	_, _, args := upd.Message.Command()
+	return args
+}
+
+// ChatID returns the chat the update came from or zero.
+func ChatID(upd *tg.Update) int64 {
+	if msg := messageOf(upd); msg != nil {
+		return msg.Chat.ID
+	}
+	switch {
+	case upd.CallbackQuery != nil:
+		return int64(upd.CallbackQuery.From.ID)
+	case upd.MyChatMember != nil:
+		return upd.MyChatMember.Chat.ID
+	}
+	return 0
+}
+
+func messageOf(upd *tg.Update) *message.Message {
+	switch {
+	case upd.Message != nil:
+		return upd.Message
+	case upd.EditedMessage != nil:
+		return upd.EditedMessage
+	}
+	return nil
+}
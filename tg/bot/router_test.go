@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"vybar/tg"
+	"vybar/tg/message"
+)
+
+// textUpdate returns a message with the text, marking the first word as a
+// command when it starts with a slash.
+func textUpdate(text string) *tg.Update {
+	msg := message.Message{Text: &text}
+	if strings.HasPrefix(text, "/") {
+		n := strings.IndexByte(text, ' ')
+		if n < 0 {
+			n = len(text)
+		}
+		msg.Entities = []message.Entity{{Type: message.EntityBotCommand, Length: n}}
+	}
+	return &tg.Update{ID: 1, Message: &msg}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		text     string
+		want     bool
+		wantArgs string
+	}{
+		{text: "/start", want: true},
+		{text: "/start 123", want: true, wantArgs: "123"},
+		{text: "/START", want: true},
+		{text: "/start@vybar_bot", want: true},
+		{text: "/start@Vybar_Bot", want: true},
+		{text: "/start@other_bot"},
+		{text: "/start@other_bot 123"},
+		{text: "/started"},
+		{text: "/stop"},
+		{text: "start"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			r := NewRouter("Vybar_Bot")
+			var got bool
+			var args string
+			r.Command("start", func(ctx context.Context, upd *tg.Update) error {
+				got = true
+				args = Args(upd)
+				return nil
+			})
+			if err := r.Serve(context.Background(), textUpdate(tt.text)); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || args != tt.wantArgs {
+				t.Errorf("handled %t with %q, want %t with %q", got, args, tt.want, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCommandNotAtStart(t *testing.T) {
+	text := "say /start"
+	upd := tg.Update{Message: &message.Message{
+		Text:     &text,
+		Entities: []message.Entity{{Type: message.EntityBotCommand, Offset: 4, Length: 6}},
+	}}
+	r := NewRouter("vybar_bot")
+	r.Command("start", func(ctx context.Context, upd *tg.Update) error {
+		t.Error("command in the middle of a text is handled")
+		return nil
+	})
+	if err := r.Serve(context.Background(), &upd); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	r := NewRouter("vybar_bot")
+	var got []string
+	r.Command("start", func(ctx context.Context, upd *tg.Update) error {
+		got = append(got, "command")
+		return ErrSkip
+	})
+	r.AnyText(func(ctx context.Context, upd *tg.Update) error {
+		got = append(got, "text")
+		return nil
+	})
+	if err := r.Serve(context.Background(), textUpdate("/start")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "command,text" {
+		t.Errorf("handled by %v", got)
+	}
+}
@@ -0,0 +1,15 @@
+package callback
+
+import (
+	"vybar/tg/message"
+	"vybar/tg/user"
+)
+
+type Query struct {
+	ID              string           `json:"id"`
+	From            user.User        `json:"from"`
+	Message         *message.Message `json:"message,omitempty"`
+	InlineMessageID *string          `json:"inline_message_id,omitempty"`
+	ChatInstance    string           `json:"chat_instance"`
+	Data            *string          `json:"data,omitempty"`
+}
@@ -1,5 +1,15 @@
 package chat
 
+const (
+	TypePrivate    = "private"
+	TypeGroup      = "group"
+	TypeSupergroup = "supergroup"
+	TypeChannel    = "channel"
+)
+
 type Chat struct {
-	ID int64 `json:"id"`
+	ID       int64   `json:"id"`
+	Type     string  `json:"type,omitempty"`
+	Title    *string `json:"title,omitempty"`
+	Username *string `json:"username,omitempty"`
 }
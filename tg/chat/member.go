@@ -0,0 +1,32 @@
+package chat
+
+import "vybar/tg/user"
+
+const (
+	StatusCreator       = "creator"
+	StatusAdministrator = "administrator"
+	StatusMember        = "member"
+	StatusRestricted    = "restricted"
+	StatusLeft          = "left"
+	StatusKicked        = "kicked"
+)
+
+type Member struct {
+	User   user.User `json:"user"`
+	Status string    `json:"status"`
+}
+
+// MemberUpdated is sent when the bot is blocked or unblocked by a user or
+// added to or removed from a group.
+type MemberUpdated struct {
+	Chat          Chat      `json:"chat"`
+	From          user.User `json:"from"`
+	Date          int       `json:"date"`
+	OldChatMember Member    `json:"old_chat_member"`
+	NewChatMember Member    `json:"new_chat_member"`
+}
+
+// Blocked reports whether the user blocked the bot in a private chat.
+func (u *MemberUpdated) Blocked() bool {
+	return u.Chat.Type == TypePrivate && u.NewChatMember.Status == StatusKicked
+}
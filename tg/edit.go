@@ -0,0 +1,121 @@
+package tg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"vybar/tg/message"
+)
+
+type editRequest struct {
+	ChatID      int64           `json:"chat_id"`
+	MessageID   int             `json:"message_id"`
+	Text        *string         `json:"text,omitempty"`
+	Caption     *string         `json:"caption,omitempty"`
+	ParseMode   string          `json:"parse_mode,omitempty"`
+	ReplyMarkup json.RawMessage `json:"reply_markup,omitempty"`
+}
+
+func newEditRequest(msg *message.Message) (*editRequest, error) {
+	if msg.ID == 0 {
+		return nil, fmt.Errorf("tg: message id is required to edit a message")
+	}
+	req := editRequest{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+	}
+	if msg.Markdown {
+		req.ParseMode = "MarkdownV2"
+	}
+	if msg.ReplyMarkup != nil {
+		d, err := msg.ReplyMarkup.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		req.ReplyMarkup = d
+	}
+	return &req, nil
+}
+
+func (api *API) edit(ctx context.Context, method string, req *editRequest) (*message.Message, error) {
+	release, err := api.wait(ctx, req.ChatID, PriorityInteractive)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	r, err := api.newRequest(ctx, "POST", method, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp message.Message
+	if err := api.do(r, api.requestTimeout, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (api *API) EditMessageText(msg *message.Message) (*message.Message, error) {
+	return api.EditMessageTextContext(context.Background(), msg)
+}
+
+func (api *API) EditMessageTextContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	req, err := newEditRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Text == nil {
+		return nil, fmt.Errorf("tg: text is required to edit a message text")
+	}
+	req.Text = msg.Text
+	return api.edit(ctx, "editMessageText", req)
+}
+
+func (api *API) EditMessageCaption(msg *message.Message) (*message.Message, error) {
+	return api.EditMessageCaptionContext(context.Background(), msg)
+}
+
+func (api *API) EditMessageCaptionContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	req, err := newEditRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	req.Caption = msg.Caption
+	return api.edit(ctx, "editMessageCaption", req)
+}
+
+func (api *API) EditMessageReplyMarkup(msg *message.Message) (*message.Message, error) {
+	return api.EditMessageReplyMarkupContext(context.Background(), msg)
+}
+
+func (api *API) EditMessageReplyMarkupContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	req, err := newEditRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	req.ParseMode = ""
+	return api.edit(ctx, "editMessageReplyMarkup", req)
+}
+
+func (api *API) DeleteMessage(chatID int64, messageID int) error {
+	return api.DeleteMessageContext(context.Background(), chatID, messageID)
+}
+
+func (api *API) DeleteMessageContext(ctx context.Context, chatID int64, messageID int) error {
+	req := struct {
+		ChatID    int64 `json:"chat_id"`
+		MessageID int   `json:"message_id"`
+	}{
+		ChatID:    chatID,
+		MessageID: messageID,
+	}
+
+	r, err := api.newRequest(ctx, "POST", "deleteMessage", &req)
+	if err != nil {
+		return err
+	}
+
+	var resp bool
+	return api.do(r, api.requestTimeout, &resp)
+}
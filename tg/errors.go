@@ -0,0 +1,80 @@
+package tg
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type ResponseParameters struct {
+	MigrateToChatID *int64 `json:"migrate_to_chat_id,omitempty"`
+	RetryAfter      *int   `json:"retry_after,omitempty"`
+}
+
+// Error is returned when telegram rejects a request.
+type Error struct {
+	Code            int
+	Description     string
+	RetryAfter      time.Duration
+	MigrateToChatID int64
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("tg: telegram api returned %d", e.Code)
+	}
+	return fmt.Sprintf("tg: telegram api returned %d: %s", e.Code, e.Description)
+}
+
+func newError(statusCode int, rsp *tgResponse) *Error {
+	e := Error{
+		Code: statusCode,
+	}
+	if rsp == nil {
+		return &e
+	}
+	if rsp.ErrorCode != 0 {
+		e.Code = rsp.ErrorCode
+	}
+	e.Description = rsp.Description
+	if rsp.Parameters != nil {
+		if rsp.Parameters.RetryAfter != nil {
+			e.RetryAfter = time.Duration(*rsp.Parameters.RetryAfter) * time.Second
+		}
+		if rsp.Parameters.MigrateToChatID != nil {
+			e.MigrateToChatID = *rsp.Parameters.MigrateToChatID
+		}
+	}
+	return &e
+}
+
+func AsError(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+func hasCode(err error, code int) bool {
+	e, ok := AsError(err)
+	return ok && e.Code == code
+}
+
+// IsBlocked reports whether the user blocked the bot or the bot can not
+// write to the chat any more.
+func IsBlocked(err error) bool {
+	return hasCode(err, http.StatusForbidden)
+}
+
+// IsBadRequest reports whether telegram refused the request itself, e.g.
+// because of a malformed markup.
+func IsBadRequest(err error) bool {
+	return hasCode(err, http.StatusBadRequest)
+}
+
+// IsFloodWait reports whether telegram asked to slow down.
+func IsFloodWait(err error) bool {
+	return hasCode(err, http.StatusTooManyRequests)
+}
@@ -0,0 +1,25 @@
+package file
+
+import "strings"
+
+type Document struct {
+	FileBase
+	Thumbnail *PhotoSize `json:"thumbnail,omitempty"`
+	FileName  *string    `json:"file_name,omitempty"`
+	MimeType  *string    `json:"mime_type,omitempty"`
+}
+
+// IsVideo reports whether the document is a video sent as a file.
+func (d *Document) IsVideo() bool {
+	return d.MimeType != nil && strings.HasPrefix(*d.MimeType, "video/")
+}
+
+type Animation struct {
+	FileBase
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Duration  int        `json:"duration"`
+	Thumbnail *PhotoSize `json:"thumbnail,omitempty"`
+	FileName  *string    `json:"file_name,omitempty"`
+	MimeType  *string    `json:"mime_type,omitempty"`
+}
@@ -0,0 +1,28 @@
+package file
+
+import "io"
+
+// InputFile is a file to send, either one which is already stored on
+// telegram servers or a new upload.
+type InputFile struct {
+	ID     string
+	Name   string
+	Reader io.Reader
+}
+
+func ByID(id string) InputFile {
+	return InputFile{
+		ID: id,
+	}
+}
+
+func Upload(name string, r io.Reader) InputFile {
+	return InputFile{
+		Name:   name,
+		Reader: r,
+	}
+}
+
+func (f InputFile) IsUpload() bool {
+	return f.Reader != nil
+}
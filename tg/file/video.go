@@ -6,5 +6,14 @@ type Video struct {
 	Height    int        `json:"height"`
 	Duration  int        `json:"duration"`
 	Thumbnail *PhotoSize `json:"thumbnail,omitempty"`
-	MimeType  *string    `json:"mimetype,omitempty"`
+	FileName  *string    `json:"file_name,omitempty"`
+	MimeType  *string    `json:"mime_type,omitempty"`
+}
+
+// VideoNote is a round video message.
+type VideoNote struct {
+	FileBase
+	Length    int        `json:"length"`
+	Duration  int        `json:"duration"`
+	Thumbnail *PhotoSize `json:"thumbnail,omitempty"`
 }
@@ -3,14 +3,42 @@ package keyboard
 import "encoding/json"
 
 type InlineButton struct {
-	Text string `json:"text"`
-	URL  string `json:"url"`
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
 }
 
 type InlineMarkup struct {
 	Buttons [][]InlineButton `json:"inline_keyboard"`
 }
 
+func URLButton(text, url string) InlineButton {
+	return InlineButton{
+		Text: text,
+		URL:  url,
+	}
+}
+
+func CallbackButton(text, data string) InlineButton {
+	return InlineButton{
+		Text:         text,
+		CallbackData: data,
+	}
+}
+
+func InlineRow(buttons ...InlineButton) []InlineButton {
+	return buttons
+}
+
+func NewInlineMarkup(rows ...[]InlineButton) *InlineMarkup {
+	if rows == nil {
+		rows = make([][]InlineButton, 0)
+	}
+	return &InlineMarkup{
+		Buttons: rows,
+	}
+}
+
 func (im *InlineMarkup) Serialize() ([]byte, error) {
 	return json.Marshal(im)
 }
@@ -0,0 +1,198 @@
+package tg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"vybar/tg/file"
+	"vybar/tg/message"
+)
+
+func (api *API) SendPhoto(msg *message.Message) (*message.Message, error) {
+	return api.SendPhotoContext(context.Background(), msg)
+}
+
+func (api *API) SendPhotoContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	return api.sendMedia(ctx, "sendPhoto", message.MediaPhoto, msg)
+}
+
+func (api *API) SendVideo(msg *message.Message) (*message.Message, error) {
+	return api.SendVideoContext(context.Background(), msg)
+}
+
+func (api *API) SendVideoContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	return api.sendMedia(ctx, "sendVideo", message.MediaVideo, msg)
+}
+
+func (api *API) SendVideoNote(msg *message.Message) (*message.Message, error) {
+	return api.SendVideoNoteContext(context.Background(), msg)
+}
+
+func (api *API) SendVideoNoteContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	return api.sendMedia(ctx, "sendVideoNote", message.MediaVideoNote, msg)
+}
+
+func (api *API) SendAnimation(msg *message.Message) (*message.Message, error) {
+	return api.SendAnimationContext(context.Background(), msg)
+}
+
+func (api *API) SendAnimationContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	return api.sendMedia(ctx, "sendAnimation", message.MediaAnimation, msg)
+}
+
+func (api *API) SendDocument(msg *message.Message) (*message.Message, error) {
+	return api.SendDocumentContext(context.Background(), msg)
+}
+
+func (api *API) SendDocumentContext(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	return api.sendMedia(ctx, "sendDocument", message.MediaDocument, msg)
+}
+
+func priority(msg *message.Message) Priority {
+	if msg.Bulk {
+		return PriorityBulk
+	}
+	return PriorityInteractive
+}
+
+// messageFields returns form fields common for all send methods.
+func messageFields(msg *message.Message) (map[string]string, error) {
+	fields := map[string]string{
+		"chat_id": strconv.FormatInt(msg.Chat.ID, 10),
+	}
+	if msg.Caption != nil {
+		fields["caption"] = *msg.Caption
+	}
+	if msg.Markdown {
+		fields["parse_mode"] = "MarkdownV2"
+	}
+	if msg.ReplyToMessage != nil {
+		fields["reply_to_message_id"] = strconv.Itoa(msg.ReplyToMessage.ID)
+	}
+	if msg.ReplyMarkup != nil {
+		d, err := msg.ReplyMarkup.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		fields["reply_markup"] = string(d)
+	}
+	return fields, nil
+}
+
+func (api *API) sendMedia(ctx context.Context, method, field string, msg *message.Message) (*message.Message, error) {
+	if msg.Input == nil {
+		return nil, fmt.Errorf("tg: %s without a file", method)
+	}
+	if msg.InputType != "" && msg.InputType != field {
+		return nil, fmt.Errorf("tg: %s can not send %s", method, msg.InputType)
+	}
+
+	fields, err := messageFields(msg)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]multipartFile, 0, 1)
+	if msg.Input.IsUpload() {
+		files = append(files, multipartFile{
+			field:  field,
+			name:   fileName(*msg.Input, field),
+			reader: msg.Input.Reader,
+		})
+	} else {
+		fields[field] = msg.Input.ID
+	}
+
+	release, err := api.wait(ctx, msg.Chat.ID, priority(msg))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	r, err := api.newMultipartRequest(ctx, method, fields, files)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp message.Message
+	if err := api.do(r, api.fileTimeout, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func fileName(f file.InputFile, fallback string) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return fallback
+}
+
+func (api *API) SendMediaGroup(msg *message.Message) ([]*message.Message, error) {
+	return api.SendMediaGroupContext(context.Background(), msg)
+}
+
+func (api *API) SendMediaGroupContext(ctx context.Context, msg *message.Message) ([]*message.Message, error) {
+	if len(msg.Group) < 2 || len(msg.Group) > 10 {
+		return nil, fmt.Errorf("tg: media group must contain from 2 to 10 items, got %d", len(msg.Group))
+	}
+	if msg.ReplyMarkup != nil {
+		return nil, fmt.Errorf("tg: media group can not have a keyboard")
+	}
+
+	type inputMedia struct {
+		Type      string `json:"type"`
+		Media     string `json:"media"`
+		Caption   string `json:"caption,omitempty"`
+		ParseMode string `json:"parse_mode,omitempty"`
+	}
+	items := make([]inputMedia, 0, len(msg.Group))
+	files := make([]multipartFile, 0)
+	for i, m := range msg.Group {
+		item := inputMedia{
+			Type:    m.Type,
+			Media:   m.File.ID,
+			Caption: m.Caption,
+		}
+		if m.Markdown {
+			item.ParseMode = "MarkdownV2"
+		}
+		if m.File.IsUpload() {
+			attach := fmt.Sprintf("file%d", i)
+			item.Media = "attach://" + attach
+			files = append(files, multipartFile{
+				field:  attach,
+				name:   fileName(m.File, attach),
+				reader: m.File.Reader,
+			})
+		}
+		items = append(items, item)
+	}
+
+	fields, err := messageFields(msg)
+	if err != nil {
+		return nil, err
+	}
+	d, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	fields["media"] = string(d)
+
+	release, err := api.wait(ctx, msg.Chat.ID, priority(msg))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	r, err := api.newMultipartRequest(ctx, "sendMediaGroup", fields, files)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []*message.Message
+	if err := api.do(r, api.fileTimeout, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
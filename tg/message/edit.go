@@ -0,0 +1,38 @@
+package message
+
+import "vybar/tg/chat"
+
+// EditText builds a new text for a message sent to the chat earlier. The
+// keyboard of the message is removed unless it is passed again.
+func EditText(chatID int64, messageID int, text string, options ...Option) *Message {
+	msg := Text(chatID, text, options...)
+	msg.ID = messageID
+	return msg
+}
+
+// EditCaption builds a new caption for a media message sent earlier.
+func EditCaption(chatID int64, messageID int, caption string, options ...Option) *Message {
+	msg := Message{
+		ID: messageID,
+		Chat: chat.Chat{
+			ID: chatID,
+		},
+		Caption: &caption,
+	}
+	for _, opt := range options {
+		opt(&msg)
+	}
+	return &msg
+}
+
+// EditMarkup builds a new keyboard for a message sent earlier, nil keyboard
+// removes it.
+func EditMarkup(chatID int64, messageID int, kb Keyboard) *Message {
+	return &Message{
+		ID: messageID,
+		Chat: chat.Chat{
+			ID: chatID,
+		},
+		ReplyMarkup: kb,
+	}
+}
@@ -0,0 +1,59 @@
+package message
+
+import (
+	"strings"
+	"unicode/utf16"
+	"vybar/tg/user"
+)
+
+const (
+	EntityMention     = "mention"
+	EntityHashtag     = "hashtag"
+	EntityBotCommand  = "bot_command"
+	EntityURL         = "url"
+	EntityTextLink    = "text_link"
+	EntityTextMention = "text_mention"
+)
+
+// Entity marks a special part of a text. Offset and Length are counted in
+// UTF-16 code units.
+type Entity struct {
+	Type     string     `json:"type"`
+	Offset   int        `json:"offset"`
+	Length   int        `json:"length"`
+	URL      *string    `json:"url,omitempty"`
+	User     *user.User `json:"user,omitempty"`
+	Language *string    `json:"language,omitempty"`
+}
+
+// Slice returns the part of the text marked by the entity.
+func (e Entity) Slice(text string) string {
+	units := utf16.Encode([]rune(text))
+	if e.Offset < 0 || e.Length < 0 || e.Offset+e.Length > len(units) {
+		return ""
+	}
+	return string(utf16.Decode(units[e.Offset : e.Offset+e.Length]))
+}
+
+// Command returns the bot command the message starts with, lower cased, the
+// bot it is addressed to with /command@bot and the rest of the text. All are
+// empty if the message is not a command.
+func (m *Message) Command() (string, string, string) {
+	if m.Text == nil {
+		return "", "", ""
+	}
+	for _, e := range m.Entities {
+		if e.Type != EntityBotCommand || e.Offset != 0 {
+			continue
+		}
+		full := e.Slice(*m.Text)
+		cmd := strings.TrimPrefix(full, "/")
+		mention := ""
+		if i := strings.Index(cmd, "@"); i >= 0 {
+			cmd, mention = cmd[:i], cmd[i+1:]
+		}
+		args := strings.TrimSpace(strings.TrimPrefix(*m.Text, full))
+		return strings.ToLower(cmd), mention, args
+	}
+	return "", "", ""
+}
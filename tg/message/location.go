@@ -0,0 +1,14 @@
+package message
+
+type Location struct {
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+}
+
+type Contact struct {
+	PhoneNumber string  `json:"phone_number"`
+	FirstName   string  `json:"first_name"`
+	LastName    *string `json:"last_name,omitempty"`
+	UserID      *int64  `json:"user_id,omitempty"`
+	VCard       *string `json:"vcard,omitempty"`
+}
@@ -0,0 +1,75 @@
+package message
+
+import (
+	"vybar/tg/chat"
+	"vybar/tg/file"
+)
+
+const (
+	MediaPhoto     = "photo"
+	MediaVideo     = "video"
+	MediaVideoNote = "video_note"
+	MediaAnimation = "animation"
+	MediaDocument  = "document"
+)
+
+// InputMedia is an item of a media group.
+type InputMedia struct {
+	Type     string
+	File     file.InputFile
+	Caption  string
+	Markdown bool
+}
+
+func media(kind string, chatID int64, f file.InputFile, caption string, options ...Option) *Message {
+	msg := Message{
+		Chat: chat.Chat{
+			ID: chatID,
+		},
+		Input:     &f,
+		InputType: kind,
+	}
+	if caption != "" {
+		msg.Caption = &caption
+	}
+	for _, opt := range options {
+		opt(&msg)
+	}
+	return &msg
+}
+
+func Photo(chatID int64, f file.InputFile, caption string, options ...Option) *Message {
+	return media(MediaPhoto, chatID, f, caption, options...)
+}
+
+func Video(chatID int64, f file.InputFile, caption string, options ...Option) *Message {
+	return media(MediaVideo, chatID, f, caption, options...)
+}
+
+// VideoNote is a round video, telegram does not allow captions for them.
+func VideoNote(chatID int64, f file.InputFile, options ...Option) *Message {
+	return media(MediaVideoNote, chatID, f, "", options...)
+}
+
+func Animation(chatID int64, f file.InputFile, caption string, options ...Option) *Message {
+	return media(MediaAnimation, chatID, f, caption, options...)
+}
+
+func Document(chatID int64, f file.InputFile, caption string, options ...Option) *Message {
+	return media(MediaDocument, chatID, f, caption, options...)
+}
+
+// Group builds an album of 2-10 photos and videos or documents. Keyboards are
+// not supported by telegram for albums.
+func Group(chatID int64, items []InputMedia, options ...Option) *Message {
+	msg := Message{
+		Chat: chat.Chat{
+			ID: chatID,
+		},
+		Group: items,
+	}
+	for _, opt := range options {
+		opt(&msg)
+	}
+	return &msg
+}
@@ -8,18 +8,34 @@ import (
 )
 
 type Message struct {
-	ID             int               `json:"message_id,omitempty"`
-	From           *user.User        `json:"from,omitempty"`
-	Date           int               `json:"date,omitempty"`
-	DateTime       time.Time         `json:"-"`
-	ForwardFrom    *user.User        `json:"forward_from"`
-	Text           *string           `json:"text,omitempty"`
-	Chat           chat.Chat         `json:"chat"`
-	ReplyToMessage *Message          `json:"reply_to_message,omitempty"`
-	Photo          []*file.PhotoSize `json:"photo,omitempty"`
-	Video          *file.Video       `json:"video,omitempty"`
-	ReplyMarkup    Keyboard          `json:"-"`
-	Markdown       bool              `json:"-"`
+	ID              int               `json:"message_id,omitempty"`
+	From            *user.User        `json:"from,omitempty"`
+	Date            int               `json:"date,omitempty"`
+	DateTime        time.Time         `json:"-"`
+	ForwardFrom     *user.User        `json:"forward_from"`
+	EditDate        *int              `json:"edit_date,omitempty"`
+	MediaGroupID    *string           `json:"media_group_id,omitempty"`
+	Text            *string           `json:"text,omitempty"`
+	Entities        []Entity          `json:"entities,omitempty"`
+	Caption         *string           `json:"caption,omitempty"`
+	CaptionEntities []Entity          `json:"caption_entities,omitempty"`
+	Chat            chat.Chat         `json:"chat"`
+	ReplyToMessage  *Message          `json:"reply_to_message,omitempty"`
+	Photo           []*file.PhotoSize `json:"photo,omitempty"`
+	Video           *file.Video       `json:"video,omitempty"`
+	VideoNote       *file.VideoNote   `json:"video_note,omitempty"`
+	Animation       *file.Animation   `json:"animation,omitempty"`
+	Document        *file.Document    `json:"document,omitempty"`
+	Location        *Location         `json:"location,omitempty"`
+	Contact         *Contact          `json:"contact,omitempty"`
+	ReplyMarkup     Keyboard          `json:"-"`
+	Markdown        bool              `json:"-"`
+	Bulk            bool              `json:"-"`
+
+	// Input, InputType and Group describe media to send
+	Input     *file.InputFile `json:"-"`
+	InputType string          `json:"-"`
+	Group     []InputMedia    `json:"-"`
 }
 
 type Keyboard interface {
@@ -48,6 +64,14 @@ func Markdown() Option {
 	}
 }
 
+// Bulk queues the message behind interactive replies, for broadcasts and
+// notifications.
+func Bulk() Option {
+	return func(msg *Message) {
+		msg.Bulk = true
+	}
+}
+
 func Text(chatID int64, body string, options ...Option) *Message {
 	text := body
 	msg := Message{
@@ -0,0 +1,289 @@
+package tg
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned for messages which were waiting for their turn when
+// the client was closed, and for messages sent once it is flushed.
+var ErrClosed = errors.New("tg: client is closed")
+
+type Priority int
+
+const (
+	// PriorityInteractive is used for replies to users who are waiting for
+	// the bot right now.
+	PriorityInteractive Priority = iota
+	// PriorityBulk is used for broadcasts and notifications, which are sent
+	// only when no interactive message is waiting.
+	PriorityBulk
+)
+
+// Telegram allows about 30 messages per second overall and about one
+// message per second to the same chat.
+const (
+	defaultGlobalRate = 30
+	defaultChatRate   = 1
+	defaultChatBurst  = 3
+)
+
+type QueueDepth struct {
+	Interactive int
+	Bulk        int
+}
+
+func (d QueueDepth) Total() int {
+	return d.Interactive + d.Bulk
+}
+
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64, now time.Time) *bucket {
+	return &bucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// delay returns how long to wait for the next token.
+func (b *bucket) delay(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+type sendTicket struct {
+	chatID int64
+	ready  chan struct{}
+}
+
+// scheduler hands out permissions to send messages. Tickets are granted in
+// order of priority and then arrival, skipping chats which are over their
+// own limit so one busy chat does not hold back everyone else.
+type scheduler struct {
+	mu       sync.Mutex
+	global   *bucket
+	chats    map[int64]*bucket
+	chatRate float64
+	burst    float64
+	queues   [2][]*sendTicket
+	wakeup   chan struct{}
+	// inflight counts granted messages which are not sent yet
+	inflight int
+	// closing is set by drain, new messages are refused after it
+	closing bool
+	// done is closed when run stops
+	done chan struct{}
+}
+
+func newScheduler(globalRate, chatRate float64, chatBurst int) *scheduler {
+	now := time.Now()
+	return &scheduler{
+		global:   newBucket(globalRate, globalRate, now),
+		chats:    make(map[int64]*bucket),
+		chatRate: chatRate,
+		burst:    float64(chatBurst),
+		wakeup:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// wait blocks until a message to the chat may be sent. Once it returns nil,
+// release must be called when the message is sent. It fails with ErrClosed
+// once the scheduler is stopped or drained.
+func (s *scheduler) wait(ctx context.Context, chatID int64, prio Priority) error {
+	if prio != PriorityBulk {
+		prio = PriorityInteractive
+	}
+	t := sendTicket{
+		chatID: chatID,
+		ready:  make(chan struct{}),
+	}
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	s.queues[prio] = append(s.queues[prio], &t)
+	s.mu.Unlock()
+	s.notify()
+
+	err := ErrClosed
+	select {
+	case <-t.ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-s.done:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-t.ready:
+		// granted while we were cancelled, nothing to clean up
+		return nil
+	default:
+	}
+	q := s.queues[prio]
+	for i := range q {
+		if q[i] == &t {
+			s.queues[prio] = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	return err
+}
+
+func (s *scheduler) release() {
+	s.mu.Lock()
+	s.inflight--
+	s.mu.Unlock()
+}
+
+// drain refuses new messages and waits until the queued ones are granted and
+// all granted ones are sent.
+func (s *scheduler) drain(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for !s.drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			return ErrClosed
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (s *scheduler) drained() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queues[PriorityInteractive])+len(s.queues[PriorityBulk])+s.inflight == 0
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) depth() QueueDepth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return QueueDepth{
+		Interactive: len(s.queues[PriorityInteractive]),
+		Bulk:        len(s.queues[PriorityBulk]),
+	}
+}
+
+func (s *scheduler) chat(chatID int64, now time.Time) *bucket {
+	b, ok := s.chats[chatID]
+	if !ok {
+		b = newBucket(s.chatRate, s.burst, now)
+		s.chats[chatID] = b
+	}
+	return b
+}
+
+// dispatch grants as many tickets as the limits allow and returns how long
+// to sleep before the next attempt, or zero if the queues are empty.
+func (s *scheduler) dispatch(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Duration
+	sooner := func(d time.Duration) {
+		if next == 0 || d < next {
+			next = d
+		}
+	}
+	for prio := range s.queues {
+		q := s.queues[prio]
+		kept := q[:0]
+		for _, t := range q {
+			if d := s.global.delay(now); d > 0 {
+				sooner(d)
+				kept = append(kept, t)
+				continue
+			}
+			b := s.chat(t.chatID, now)
+			if d := b.delay(now); d > 0 {
+				sooner(d)
+				kept = append(kept, t)
+				continue
+			}
+			s.global.tokens--
+			b.tokens--
+			s.inflight++
+			close(t.ready)
+		}
+		for i := len(kept); i < len(q); i++ {
+			q[i] = nil
+		}
+		s.queues[prio] = kept
+	}
+
+	for chatID, b := range s.chats {
+		if b.full(now) {
+			delete(s.chats, chatID)
+		}
+	}
+	return next
+}
+
+func (s *scheduler) run(ctx context.Context) {
+	defer close(s.done)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		next := s.dispatch(time.Now())
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next > 0 {
+			timer.Reset(next)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wakeup:
+		case <-timer.C:
+		}
+	}
+}
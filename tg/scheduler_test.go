@@ -0,0 +1,140 @@
+package tg
+
+import (
+	"testing"
+	"time"
+)
+
+type testTicket struct {
+	chatID int64
+	prio   Priority
+}
+
+// enqueue queues tickets the way wait does, without blocking.
+func enqueue(s *scheduler, tickets []testTicket) []*sendTicket {
+	res := make([]*sendTicket, 0, len(tickets))
+	for _, tt := range tickets {
+		t := sendTicket{
+			chatID: tt.chatID,
+			ready:  make(chan struct{}),
+		}
+		s.queues[tt.prio] = append(s.queues[tt.prio], &t)
+		res = append(res, &t)
+	}
+	return res
+}
+
+func granted(tickets []*sendTicket) []int {
+	var res []int
+	for i, t := range tickets {
+		select {
+		case <-t.ready:
+			res = append(res, i)
+		default:
+		}
+	}
+	return res
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		globalRate float64
+		chatBurst  int
+		tickets    []testTicket
+		want       []int
+	}{
+		{
+			name:       "priority before arrival",
+			globalRate: 1,
+			chatBurst:  3,
+			tickets:    []testTicket{{1, PriorityBulk}, {2, PriorityInteractive}},
+			want:       []int{1},
+		},
+		{
+			name:       "arrival within a priority",
+			globalRate: 1,
+			chatBurst:  3,
+			tickets:    []testTicket{{1, PriorityInteractive}, {2, PriorityInteractive}},
+			want:       []int{0},
+		},
+		{
+			name:       "global limit",
+			globalRate: 2,
+			chatBurst:  3,
+			tickets:    []testTicket{{1, PriorityBulk}, {2, PriorityBulk}, {3, PriorityBulk}},
+			want:       []int{0, 1},
+		},
+		{
+			name:       "chat burst",
+			globalRate: 30,
+			chatBurst:  3,
+			tickets:    []testTicket{{1, PriorityBulk}, {1, PriorityBulk}, {1, PriorityBulk}, {1, PriorityBulk}},
+			want:       []int{0, 1, 2},
+		},
+		{
+			name:       "chat over its limit does not hold back others",
+			globalRate: 30,
+			chatBurst:  1,
+			tickets:    []testTicket{{1, PriorityInteractive}, {1, PriorityInteractive}, {2, PriorityInteractive}},
+			want:       []int{0, 2},
+		},
+		{
+			name:       "chat limit is shared by priorities",
+			globalRate: 30,
+			chatBurst:  1,
+			tickets:    []testTicket{{1, PriorityBulk}, {1, PriorityInteractive}, {2, PriorityBulk}},
+			want:       []int{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduler(tt.globalRate, 1, tt.chatBurst)
+			tickets := enqueue(s, tt.tickets)
+			next := s.dispatch(time.Now())
+
+			got := granted(tickets)
+			if !equalInts(got, tt.want) {
+				t.Errorf("granted %v, want %v", got, tt.want)
+			}
+			if s.inflight != len(tt.want) {
+				t.Errorf("%d messages in flight, want %d", s.inflight, len(tt.want))
+			}
+			left := len(tt.tickets) - len(tt.want)
+			if d := s.depth(); d.Total() != left {
+				t.Errorf("%d messages queued, want %d", d.Total(), left)
+			}
+			if (next > 0) != (left > 0) {
+				t.Errorf("next dispatch in %s with %d messages queued", next, left)
+			}
+		})
+	}
+}
+
+func TestDispatchKeepsOrderOfWaitingMessages(t *testing.T) {
+	s := newScheduler(30, 1, 1)
+	tickets := enqueue(s, []testTicket{
+		{1, PriorityInteractive},
+		{1, PriorityInteractive},
+		{1, PriorityInteractive},
+	})
+	now := time.Now()
+	for i := range tickets {
+		s.dispatch(now.Add(time.Duration(i) * time.Second))
+		if got, want := granted(tickets), []int{0, 1, 2}[:i+1]; !equalInts(got, want) {
+			t.Fatalf("after %ds granted %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package tg
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
+	"vybar/tg/callback"
+	"vybar/tg/chat"
+	"vybar/tg/message"
+)
+
+type Update struct {
+	ID            int                 `json:"update_id"`
+	Message       *message.Message    `json:"message"`
+	EditedMessage *message.Message    `json:"edited_message,omitempty"`
+	CallbackQuery *callback.Query     `json:"callback_query,omitempty"`
+	MyChatMember  *chat.MemberUpdated `json:"my_chat_member,omitempty"`
+}
+
+// OffsetStore keeps the offset of the next update, so a restarted bot
+// neither replays nor drops updates.
+type OffsetStore interface {
+	LoadOffset(ctx context.Context) (int, error)
+	SaveOffset(ctx context.Context, offset int) error
+}
+
+// UpdateStore keeps received updates until they are handled. Telegram
+// forgets an update once it is delivered, so it is saved before that.
+type UpdateStore interface {
+	SavePendingUpdate(ctx context.Context, id int, data json.RawMessage) error
+}
+
+// PollTimeout sets how long telegram holds a getUpdates request open when
+// there are no updates.
+func PollTimeout(d time.Duration) Option {
+	return func(a *API) {
+		a.pollTimeout = d
+	}
+}
+
+// AllowedUpdates limits the kinds of updates telegram delivers, e.g.
+// "message" and "callback_query".
+func AllowedUpdates(kinds ...string) Option {
+	return func(a *API) {
+		a.allowedUpdates = kinds
+	}
+}
+
+func WithOffsetStore(s OffsetStore) Option {
+	return func(a *API) {
+		a.offsetStore = s
+	}
+}
+
+// KeepUpdates saves every received update to s before it is confirmed to
+// telegram, so updates which were received but not handled yet survive
+// a crash. The receiver deletes them once handled and replays the rest on
+// start.
+func KeepUpdates(s UpdateStore) Option {
+	return func(a *API) {
+		a.updateStore = s
+	}
+}
+
+func (api *API) keepUpdates(ctx context.Context, upds []*Update) error {
+	if api.updateStore == nil {
+		return nil
+	}
+	for _, upd := range upds {
+		data, err := json.Marshal(upd)
+		if err != nil {
+			return err
+		}
+		if err := api.updateStore.SavePendingUpdate(ctx, upd.ID, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backoff sets bounds of delays between retries of failed getUpdates requests.
+func Backoff(min, max time.Duration) Option {
+	return func(a *API) {
+		a.minBackoff = min
+		a.maxBackoff = max
+	}
+}
+
+func (api *API) GetUpdatesContext(ctx context.Context, offset int) ([]*Update, error) {
+	prms := make(url.Values)
+	if api.pollTimeout > 0 {
+		prms.Add("timeout", strconv.Itoa(int(api.pollTimeout/time.Second)))
+	}
+	if offset != 0 {
+		prms.Add("offset", strconv.Itoa(offset))
+	}
+	if api.allowedUpdates != nil {
+		d, err := json.Marshal(api.allowedUpdates)
+		if err != nil {
+			return nil, err
+		}
+		prms.Add("allowed_updates", string(d))
+	}
+	u := &url.URL{
+		Path:     "getUpdates",
+		RawQuery: prms.Encode(),
+	}
+	req, err := api.newRequest(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Update
+	// telegram holds the request for the poll timeout
+	if err := api.do(req, api.pollTimeout+api.requestTimeout, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// backoff returns a random delay within exponentially growing bounds.
+func (api *API) backoff(rnd *rand.Rand, attempt int) time.Duration {
+	d := api.minBackoff
+	for i := 0; i < attempt && d < api.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > api.maxBackoff {
+		d = api.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rnd.Int63n(int64(d/2)+1))
+}
+
+// GetUpdatesChan long polls telegram until ctx is done, retrying failed
+// requests with backoff. If offset is zero, it is loaded from the offset
+// store. The offset of every update handed to the channel is saved. With
+// KeepUpdates, updates are saved before the next request confirms them.
+func (api *API) GetUpdatesChan(ctx context.Context, offset int) (<-chan Update, error) {
+	if offset == 0 && api.offsetStore != nil {
+		stored, err := api.offsetStore.LoadOffset(ctx)
+		if err != nil {
+			return nil, err
+		}
+		offset = stored
+	}
+
+	result := make(chan Update)
+
+	go func() {
+		defer close(result)
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		attempt := 0
+		for {
+			upds, err := api.GetUpdatesContext(ctx, offset)
+			if err == nil {
+				// updates which were not kept are not confirmed either,
+				// telegram sends them again
+				err = api.keepUpdates(ctx, upds)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					api.logger.Infof("tg: stop updates worker: %s", ctx.Err().Error())
+					return
+				}
+				delay := api.backoff(rnd, attempt)
+				attempt++
+				api.logger.Errorf("tg: failed to get updates, retry in %s: %s", delay, err.Error())
+				select {
+				case <-ctx.Done():
+					api.logger.Infof("tg: stop updates worker: %s", ctx.Err().Error())
+					return
+				case <-time.After(delay):
+				}
+				continue
+			}
+			attempt = 0
+
+			for _, upd := range upds {
+				select {
+				case result <- *upd:
+				case <-ctx.Done():
+					api.logger.Infof("tg: stop updates worker: %s", ctx.Err().Error())
+					return
+				}
+				offset = upd.ID + 1
+				if api.offsetStore != nil {
+					if err := api.offsetStore.SaveOffset(ctx, offset); err != nil {
+						api.logger.Errorf("tg: failed to save offset: %s", err.Error())
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				api.logger.Infof("tg: stop updates worker: %s", ctx.Err().Error())
+				return
+			default:
+				continue
+			}
+		}
+	}()
+
+	return result, nil
+}
@@ -0,0 +1,255 @@
+package tg
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+type WebhookConfig struct {
+	URL string
+	// Certificate is a public key of a self-signed certificate, it is
+	// uploaded to telegram if set.
+	Certificate        io.Reader
+	SecretToken        string
+	IPAddress          string
+	MaxConnections     int
+	AllowedUpdates     []string
+	DropPendingUpdates bool
+}
+
+type WebhookInfo struct {
+	URL                          string   `json:"url"`
+	HasCustomCertificate         bool     `json:"has_custom_certificate"`
+	PendingUpdateCount           int      `json:"pending_update_count"`
+	IPAddress                    *string  `json:"ip_address,omitempty"`
+	LastErrorDate                *int     `json:"last_error_date,omitempty"`
+	LastErrorMessage             *string  `json:"last_error_message,omitempty"`
+	LastSynchronizationErrorDate *int     `json:"last_synchronization_error_date,omitempty"`
+	MaxConnections               *int     `json:"max_connections,omitempty"`
+	AllowedUpdates               []string `json:"allowed_updates,omitempty"`
+}
+
+func (api *API) SetWebhook(cfg WebhookConfig) error {
+	return api.SetWebhookContext(context.Background(), cfg)
+}
+
+func (api *API) SetWebhookContext(ctx context.Context, cfg WebhookConfig) error {
+	fields := map[string]string{
+		"url": cfg.URL,
+	}
+	if cfg.SecretToken != "" {
+		fields["secret_token"] = cfg.SecretToken
+	}
+	if cfg.IPAddress != "" {
+		fields["ip_address"] = cfg.IPAddress
+	}
+	if cfg.MaxConnections > 0 {
+		fields["max_connections"] = strconv.Itoa(cfg.MaxConnections)
+	}
+	if cfg.AllowedUpdates != nil {
+		d, err := json.Marshal(cfg.AllowedUpdates)
+		if err != nil {
+			return err
+		}
+		fields["allowed_updates"] = string(d)
+	}
+	if cfg.DropPendingUpdates {
+		fields["drop_pending_updates"] = "true"
+	}
+
+	files := make([]multipartFile, 0)
+	if cfg.Certificate != nil {
+		files = append(files, multipartFile{
+			field:  "certificate",
+			name:   "cert.pem",
+			reader: cfg.Certificate,
+		})
+	}
+
+	r, err := api.newMultipartRequest(ctx, "setWebhook", fields, files)
+	if err != nil {
+		return err
+	}
+
+	var resp bool
+	return api.do(r, api.requestTimeout, &resp)
+}
+
+func (api *API) DeleteWebhook(dropPendingUpdates bool) error {
+	return api.DeleteWebhookContext(context.Background(), dropPendingUpdates)
+}
+
+func (api *API) DeleteWebhookContext(ctx context.Context, dropPendingUpdates bool) error {
+	req := struct {
+		DropPendingUpdates bool `json:"drop_pending_updates,omitempty"`
+	}{
+		DropPendingUpdates: dropPendingUpdates,
+	}
+
+	r, err := api.newRequest(ctx, "POST", "deleteWebhook", &req)
+	if err != nil {
+		return err
+	}
+
+	var resp bool
+	return api.do(r, api.requestTimeout, &resp)
+}
+
+func (api *API) GetWebhookInfo() (*WebhookInfo, error) {
+	return api.GetWebhookInfoContext(context.Background())
+}
+
+func (api *API) GetWebhookInfoContext(ctx context.Context) (*WebhookInfo, error) {
+	r, err := api.newRequest(ctx, "GET", "getWebhookInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp WebhookInfo
+	if err := api.do(r, api.requestTimeout, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WebhookHandler accepts updates pushed by telegram and passes them to the
+// channel. Requests without the secret token are rejected when secret is set.
+// With KeepUpdates, an update is saved before telegram gets the answer.
+type WebhookHandler struct {
+	secret  string
+	updates chan<- Update
+	logger  Logger
+	keep    func(ctx context.Context, upds []*Update) error
+
+	done     <-chan struct{}
+	inFlight sync.WaitGroup
+}
+
+func (api *API) NewWebhookHandler(secret string, updates chan<- Update) *WebhookHandler {
+	return &WebhookHandler{
+		secret:  secret,
+		updates: updates,
+		logger:  api.logger,
+		keep:    api.keepUpdates,
+	}
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.inFlight.Add(1)
+	defer h.inFlight.Done()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if h.secret != "" {
+		token := r.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var upd Update
+	if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+		h.logger.Errorf("tg: failed to decode webhook update: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err := h.keep(r.Context(), []*Update{&upd}); err != nil {
+		h.logger.Errorf("tg: failed to keep webhook update: %s", err.Error())
+		// telegram will deliver the update again
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	select {
+	case h.updates <- upd:
+		w.WriteHeader(http.StatusOK)
+	case <-r.Context().Done():
+		// telegram will deliver the update again
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	case <-h.done:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+}
+
+type WebhookServerConfig struct {
+	Listen string
+	Path   string
+	Secret string
+	// CertFile and KeyFile turn TLS on, telegram accepts webhooks only over
+	// HTTPS, so they are required unless TLS is terminated by a proxy.
+	CertFile string
+	KeyFile  string
+}
+
+// ListenWebhook serves webhook requests until ctx is done. The channel is
+// closed after the server is shut down. Secret is required.
+func (api *API) ListenWebhook(ctx context.Context, cfg WebhookServerConfig) (<-chan Update, error) {
+	if cfg.Secret == "" {
+		// without it anyone who finds the endpoint can forge updates
+		return nil, errors.New("tg: webhook secret is required")
+	}
+	result := make(chan Update)
+	path := cfg.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	handler := api.NewWebhookHandler(cfg.Secret, result)
+	handler.done = ctx.Done()
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	srv := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: mux,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		if cfg.CertFile != "" {
+			err = srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	go func() {
+		defer close(result)
+		// no handler may send to the channel after it is closed
+		defer handler.inFlight.Wait()
+		select {
+		case <-ctx.Done():
+			api.logger.Infof("tg: stop webhook server: %s", ctx.Err().Error())
+		case err, ok := <-errCh:
+			if ok {
+				api.logger.Errorf("tg: webhook server failed: %s", err.Error())
+			}
+			return
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			api.logger.Errorf("tg: failed to shutdown webhook server: %s", err.Error())
+		}
+	}()
+
+	return result, nil
+}